@@ -0,0 +1,86 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import "net/http"
+
+// AllowHosts wraps a http.Handler and only calls it for
+// requests with a Host header in the allowlist. This
+// protects handlers that trust r.Host, like HostRedirect
+// and RedirectToHTTPS, against forged Host headers and
+// protects local services against DNS rebinding.
+//
+// Hosts may have a leading wildcard label or a port and
+// are normalized, as with HostSwitch. Hosts without a
+// port allow any port. It panics if any host is invalid.
+//
+// If checkSNI is true, requests received over TLS with a
+// TLS SNI extension must also have it in the allowlist.
+// As with SNIMatch, requests without the extension, like
+// those made to an IP address, are only checked against
+// the Host header. SNIMatch may be used to further
+// require the TLS SNI extension to match the Host header.
+//
+// reject is invoked for all other requests. If reject
+// is nil, a 400 Bad Request error will be returned
+// instead.
+func AllowHosts(h http.Handler, hosts []string, checkSNI bool, reject http.Handler) Handler {
+	if reject == nil {
+		reject = ErrorCode(http.StatusBadRequest)
+	}
+
+	ah := &allowHosts{
+		h:        h,
+		checkSNI: checkSNI,
+		reject:   reject,
+	}
+
+	for _, host := range hosts {
+		norm, port, err := parseHostPattern(host)
+		if err != nil {
+			panic(err)
+		}
+
+		ah.routes.add(norm, port, h)
+	}
+
+	return ah
+}
+
+// AllowHostsWrap returns a Middleware that calls
+// AllowHosts.
+func AllowHostsWrap(hosts []string, checkSNI bool, reject http.Handler) Middleware {
+	return func(h http.Handler) http.Handler {
+		return AllowHosts(h, hosts, checkSNI, reject)
+	}
+}
+
+type allowHosts struct {
+	h        http.Handler
+	routes   hostRoutes
+	checkSNI bool
+	reject   http.Handler
+}
+
+func (ah *allowHosts) allowed(host, port string) bool {
+	_, _, ok := ah.routes.lookup(host, port)
+	return ok && host != ""
+}
+
+func (ah *allowHosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	port := requestPort(r, false)
+
+	ok := ah.allowed(requestHost(r), port)
+	if ok && ah.checkSNI && r.TLS != nil && r.TLS.ServerName != "" {
+		ok = ah.allowed(lookupHost(r.TLS.ServerName), port)
+	}
+
+	if ok {
+		ah.h.ServeHTTP(w, r)
+	} else {
+		ah.reject.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowHosts(t *testing.T) {
+	h := AllowHostsWrap([]string{
+		"example.com",
+		"*.example.org",
+		"localhost:8080",
+	}, false, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	}))
+
+	for _, tc := range []struct {
+		host string
+		code int
+	}{
+		{"example.com", 999},
+		{"EXAMPLE.com.", 999},
+		{"example.com:8443", 999},
+		{"www.example.org", 999},
+		{"example.org", http.StatusBadRequest},
+		{"localhost:8080", 999},
+		{"localhost", http.StatusBadRequest},
+		{"localhost:9090", http.StatusBadRequest},
+		{"attacker.example", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, tc.host)
+	}
+
+	assert.Panics(t, func() {
+		AllowHosts(h, []string{"exa mple.com"}, false, nil)
+	})
+}
+
+func TestAllowHostsSNI(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	})
+	reject := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://example.com/", http.StatusFound)
+	})
+
+	for _, tc := range []struct {
+		checkSNI   bool
+		serverName string
+		code       int
+	}{
+		{false, "attacker.example", 999},
+		{true, "attacker.example", http.StatusFound},
+		{true, "www.example.com", 999},
+		{true, "", 999},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		r.TLS.ServerName = tc.serverName
+
+		w := httptest.NewRecorder()
+		AllowHosts(h, []string{"example.com", "*.example.com"}, tc.checkSNI, reject).ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, "%t %s", tc.checkSNI, tc.serverName)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://192.0.2.1/", nil)
+	r.TLS.ServerName = ""
+
+	w := httptest.NewRecorder()
+	AllowHosts(h, []string{"192.0.2.1"}, true, reject).ServeHTTP(w, r)
+
+	assert.Equal(t, 999, w.Code)
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// WWWPolicy controls how CanonicalHost treats the www.
+// prefix.
+type WWWPolicy int
+
+// The policies for the www. prefix.
+const (
+	// WWWPreserve leaves the www. prefix unchanged.
+	WWWPreserve WWWPolicy = iota
+
+	// WWWStrip removes the www. prefix.
+	WWWStrip
+
+	// WWWAdd adds the www. prefix to registrable
+	// domains, like example.com or example.co.uk, but
+	// not to their subdomains.
+	WWWAdd
+)
+
+// CanonicalHost wraps a http.Handler and redirects
+// requests with a non-canonical Host header to the
+// canonical host. Requests with a canonical Host header
+// are passed through to h.
+//
+// The canonical host is lowercased, without a trailing
+// dot, with any internationalized labels converted to
+// punycode, without the default port of the scheme, and
+// with the www. prefix added or removed according to
+// www.
+//
+// The path and query are preserved. GET and HEAD requests
+// are redirected with 301 Moved Permanently and all other
+// requests with 308 Permanent Redirect, so that the
+// method and body are preserved.
+//
+// Requests for hosts in exceptions, which may have a
+// leading wildcard label or a port as with HostSwitch, are
+// passed through unchanged, as are requests with an
+// invalid or absent Host header. It panics if any
+// exception is invalid.
+func CanonicalHost(h http.Handler, www WWWPolicy, exceptions ...string) Handler {
+	ch := &canonicalHost{h: h, www: www}
+
+	for _, host := range exceptions {
+		norm, port, err := parseHostPattern(host)
+		if err != nil {
+			panic(err)
+		}
+
+		ch.exceptions.add(norm, port, h)
+	}
+
+	return ch
+}
+
+// CanonicalHostWrap returns a Middleware that calls
+// CanonicalHost.
+func CanonicalHostWrap(www WWWPolicy, exceptions ...string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return CanonicalHost(h, www, exceptions...)
+	}
+}
+
+type canonicalHost struct {
+	h          http.Handler
+	www        WWWPolicy
+	exceptions hostRoutes
+}
+
+func (ch *canonicalHost) canonical(r *http.Request) (string, bool) {
+	u := &url.URL{Host: r.Host}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil || host == "" {
+		return "", false
+	}
+
+	port := u.Port()
+	if _, _, ok := ch.exceptions.lookup(host, requestPort(r, false)); ok {
+		return "", false
+	}
+
+	isIP := net.ParseIP(host) != nil
+
+	switch ch.www {
+	case WWWStrip:
+		if strings.HasPrefix(host, "www.") && len(host) > len("www.") {
+			host = host[len("www."):]
+		}
+	case WWWAdd:
+		if !isIP && !strings.HasPrefix(host, "www.") {
+			if apex, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil && apex == host {
+				host = "www." + host
+			}
+		}
+	}
+
+	if (r.TLS != nil && port == "443") || (r.TLS == nil && port == "80") {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		host = net.JoinHostPort(host, port)
+	case isIP && strings.Contains(host, ":"):
+		host = "[" + host + "]"
+	}
+
+	return host, true
+}
+
+func (ch *canonicalHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, ok := ch.canonical(r)
+	if !ok || host == r.Host {
+		ch.h.ServeHTTP(w, r)
+		return
+	}
+
+	u := *r.URL
+	u.Host = host
+
+	if r.TLS != nil {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+
+	http.Redirect(w, r, u.String(), code)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	})
+
+	for _, tc := range []struct {
+		www      WWWPolicy
+		method   string
+		url      string
+		host     string
+		code     int
+		location string
+	}{
+		{WWWPreserve, http.MethodGet, "http://example.com/", "", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://www.example.com/", "", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://example.com/a?b=c", "Example.COM.", http.StatusMovedPermanently, "http://example.com/a?b=c"},
+		{WWWPreserve, http.MethodGet, "http://example.com/", "example.com:80", http.StatusMovedPermanently, "http://example.com/"},
+		{WWWPreserve, http.MethodGet, "https://example.com/", "example.com:443", http.StatusMovedPermanently, "https://example.com/"},
+		{WWWPreserve, http.MethodGet, "http://example.com:8080/", "", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://example.com/", "example.com:443", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://example.com/", "bücher.example", http.StatusMovedPermanently, "http://xn--bcher-kva.example/"},
+		{WWWPreserve, http.MethodGet, "http://[2001:db8::1]/", "", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://[2001:db8::1]:8080/", "", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://[2001:db8::1]/", "[2001:DB8::1]:80", http.StatusMovedPermanently, "http://[2001:db8::1]/"},
+		{WWWPreserve, http.MethodGet, "http://example.com/", "exa mple.com", 999, ""},
+		{WWWPreserve, http.MethodGet, "http://example.com/", " ", 999, ""},
+		{WWWStrip, http.MethodGet, "http://www.example.com/a/b?c", "", http.StatusMovedPermanently, "http://example.com/a/b?c"},
+		{WWWStrip, http.MethodHead, "https://WWW.example.com:8443/", "", http.StatusMovedPermanently, "https://example.com:8443/"},
+		{WWWStrip, http.MethodPost, "http://www.example.com/form", "", http.StatusPermanentRedirect, "http://example.com/form"},
+		{WWWStrip, http.MethodGet, "http://example.com/", "", 999, ""},
+		{WWWAdd, http.MethodGet, "http://example.com/x", "", http.StatusMovedPermanently, "http://www.example.com/x"},
+		{WWWAdd, http.MethodPut, "http://example.co.uk/x", "", http.StatusPermanentRedirect, "http://www.example.co.uk/x"},
+		{WWWAdd, http.MethodGet, "http://api.example.com/", "", 999, ""},
+		{WWWAdd, http.MethodGet, "http://www.example.com/", "", 999, ""},
+		{WWWAdd, http.MethodGet, "http://192.0.2.1/", "", 999, ""},
+	} {
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		if tc.host != "" {
+			r.Host = tc.host
+		}
+
+		w := httptest.NewRecorder()
+		CanonicalHostWrap(tc.www)(next).ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, "%d %s %s %s", tc.www, tc.method, tc.url, tc.host)
+		assert.Equal(t, tc.location, w.Header().Get("Location"), "%d %s %s %s", tc.www, tc.method, tc.url, tc.host)
+	}
+}
+
+func TestCanonicalHostExceptions(t *testing.T) {
+	h := CanonicalHost(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	}), WWWStrip, "www.legacy.example", "*.internal.example", "www.example.com:8443")
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"http://www.legacy.example/", 999},
+		{"http://WWW.legacy.example/", 999},
+		{"http://www.build.internal.example/", 999},
+		{"http://www.example.com:8443/", 999},
+		{"http://www.example.com/", http.StatusMovedPermanently},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.url)
+	}
+
+	assert.Panics(t, func() {
+		CanonicalHost(http.NotFoundHandler(), WWWStrip, "exa mple.com")
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ClearSiteDataType is a type of browser state that is
+// cleared by the Clear-Site-Data header.
+type ClearSiteDataType string
+
+// The types accepted by the Clear-Site-Data header.
+const (
+	ClearCache             ClearSiteDataType = "cache"
+	ClearCookies           ClearSiteDataType = "cookies"
+	ClearStorage           ClearSiteDataType = "storage"
+	ClearExecutionContexts ClearSiteDataType = "executionContexts"
+
+	// ClearAll clears all of the above, and any
+	// types added in future.
+	ClearAll ClearSiteDataType = "*"
+)
+
+// ClearSiteData sets the Clear-Site-Data header, to wipe
+// browser state for the origin, before calling Handler.
+// It is intended for logout and account deletion
+// endpoints.
+//
+// Browsers ignore Clear-Site-Data on insecure origins, so
+// it is only sent for secure requests.
+type ClearSiteData struct {
+	// The handler to call after setting the header,
+	// usually a redirect such as HostRedirect or
+	// RedirectToHTTPS. If nil, a 204 No Content
+	// response is sent.
+	Handler http.Handler
+
+	// The types of browser state to clear. If empty,
+	// all types are cleared.
+	Types []ClearSiteDataType
+
+	// Optionally reports whether the request is
+	// secure. If nil, only requests received over
+	// TLS are secure. See HSTS.IsSecure.
+	IsSecure func(*http.Request) bool
+}
+
+// Validate returns an error if any of the Types are
+// unknown.
+func (c *ClearSiteData) Validate() error {
+	for _, t := range c.Types {
+		switch t {
+		case ClearCache, ClearCookies, ClearStorage, ClearExecutionContexts, ClearAll:
+		default:
+			return fmt.Errorf("handlers: invalid Clear-Site-Data type %q", t)
+		}
+	}
+
+	return nil
+}
+
+// ClearSiteDataWrap returns a Middleware that sets the
+// Clear-Site-Data header for the given types before
+// calling the wrapped handler. It panics if any of the
+// types are invalid.
+func ClearSiteDataWrap(types ...ClearSiteDataType) Middleware {
+	c := &ClearSiteData{Types: types}
+	if err := c.Validate(); err != nil {
+		panic(err)
+	}
+
+	return func(h http.Handler) http.Handler {
+		return &ClearSiteData{
+			Handler: h,
+			Types:   types,
+		}
+	}
+}
+
+func (c *ClearSiteData) secure(r *http.Request) bool {
+	if c.IsSecure != nil {
+		return c.IsSecure(r)
+	}
+
+	return r.TLS != nil
+}
+
+// String returns the value of the Clear-Site-Data header.
+func (c *ClearSiteData) String() string {
+	if len(c.Types) == 0 {
+		return strconv.Quote(string(ClearAll))
+	}
+
+	types := make([]string, len(c.Types))
+	for i, t := range c.Types {
+		types[i] = strconv.Quote(string(t))
+	}
+
+	return strings.Join(types, ", ")
+}
+
+// ServeHTTP implements http.Handler.
+func (c *ClearSiteData) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.secure(r) {
+		w.Header().Set("Clear-Site-Data", c.String())
+	}
+
+	if c.Handler != nil {
+		c.Handler.ServeHTTP(w, r)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClearSiteData(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/logout", nil)
+
+	w := httptest.NewRecorder()
+	(&ClearSiteData{}).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, `"*"`, w.Header().Get("Clear-Site-Data"))
+
+	w = httptest.NewRecorder()
+	ClearSiteDataWrap(ClearCache, ClearCookies, ClearStorage)(
+		HostRedirect("login.example.com", http.StatusSeeOther)).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://login.example.com/logout", w.Header().Get("Location"))
+	assert.Equal(t, `"cache", "cookies", "storage"`, w.Header().Get("Clear-Site-Data"))
+
+	r = httptest.NewRequest(http.MethodPost, "http://example.com/logout", nil)
+
+	w = httptest.NewRecorder()
+	ClearSiteDataWrap(ClearExecutionContexts)(&RedirectToHTTPS{}).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "https://example.com/logout", w.Header().Get("Location"))
+	assert.NotContains(t, w.Header(), "Clear-Site-Data")
+
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	w = httptest.NewRecorder()
+	(&ClearSiteData{
+		Types:    []ClearSiteDataType{ClearCookies},
+		IsSecure: TrustForwardedProto("192.0.2.1"),
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, `"cookies"`, w.Header().Get("Clear-Site-Data"))
+
+	assert.Panics(t, func() {
+		ClearSiteDataWrap("everything")
+	})
+}
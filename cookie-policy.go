@@ -0,0 +1,323 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieSameSite is the value of a cookie's SameSite
+// attribute.
+type CookieSameSite string
+
+// The values of the SameSite attribute.
+const (
+	SameSiteLax    CookieSameSite = "Lax"
+	SameSiteStrict CookieSameSite = "Strict"
+	SameSiteNone   CookieSameSite = "None"
+)
+
+// CookiePolicy rewrites the Set-Cookie headers of a
+// response, immediately before they are written, to
+// enforce a minimum level of security. Each rewrite is
+// logged so that the handler that set the cookie can be
+// fixed.
+//
+// Regardless of the policy, cookies with a __Secure-
+// prefix are given the Secure attribute, and cookies with
+// a __Host- prefix are also given Path=/ and have their
+// Domain attribute removed, as browsers otherwise reject
+// them. Cookies with SameSite=None are given the Secure
+// attribute for the same reason.
+type CookiePolicy struct {
+	Handler http.Handler
+
+	// Adds the Secure attribute to cookies set in
+	// response to secure requests.
+	Secure bool
+
+	// Adds the HttpOnly attribute to cookies.
+	HttpOnly bool
+
+	// If non-empty, the SameSite attribute to add to
+	// cookies that do not have one.
+	SameSite CookieSameSite
+
+	// If non-zero, the maximum lifetime of persistent
+	// cookies. Session cookies are not affected.
+	MaxAge time.Duration
+
+	// Optionally reports whether the request is
+	// secure. If nil, only requests received over
+	// TLS are secure. See HSTS.IsSecure.
+	IsSecure func(*http.Request) bool
+
+	// The io.Writer rewrites are logged to, it
+	// defaults to os.Stderr.
+	Log io.Writer
+}
+
+// Validate returns an error if SameSite is not a valid
+// value or MaxAge is negative.
+func (c *CookiePolicy) Validate() error {
+	switch c.SameSite {
+	case "", SameSiteLax, SameSiteStrict, SameSiteNone:
+	default:
+		return fmt.Errorf("handlers: invalid SameSite value %q", c.SameSite)
+	}
+
+	if c.MaxAge < 0 {
+		return fmt.Errorf("handlers: cookie MaxAge must not be negative")
+	}
+
+	return nil
+}
+
+// CookiePolicyWrap returns a Middleware that calls
+// CookiePolicy with the given options. It panics if c
+// is invalid.
+func CookiePolicyWrap(c *CookiePolicy) Middleware {
+	if c != nil {
+		if err := c.Validate(); err != nil {
+			panic(err)
+		}
+	}
+
+	return func(h http.Handler) http.Handler {
+		cp := new(CookiePolicy)
+
+		if c != nil {
+			*cp = *c
+		}
+
+		cp.Handler = h
+		return cp
+	}
+}
+
+func (c *CookiePolicy) secure(r *http.Request) bool {
+	if c.IsSecure != nil {
+		return c.IsSecure(r)
+	}
+
+	return r.TLS != nil
+}
+
+// ServeHTTP implements http.Handler.
+func (c *CookiePolicy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw, hw := newHookResponseWriter(w, func(code int, sniff []byte) {
+		cookies := w.Header()["Set-Cookie"]
+		if len(cookies) == 0 {
+			return
+		}
+
+		secure, now := c.secure(r), time.Now()
+
+		var buf bytes.Buffer
+		for i, cookie := range cookies {
+			sc := parseSetCookie(cookie)
+
+			changes := c.harden(sc, secure, now)
+			if len(changes) == 0 {
+				continue
+			}
+
+			cookies[i] = sc.String()
+
+			buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+			buf.WriteString(r.Method)
+			buf.WriteByte(' ')
+			buf.WriteString(r.URL.RequestURI())
+			buf.WriteString(" rewrote cookie ")
+			buf.WriteString(strconv.Quote(sc.name))
+			buf.WriteString(": ")
+			buf.WriteString(strings.Join(changes, ", "))
+			buf.WriteByte('\n')
+		}
+
+		if buf.Len() == 0 {
+			return
+		}
+
+		out := c.Log
+		if out == nil {
+			out = os.Stderr
+		}
+
+		buf.WriteTo(out)
+	})
+
+	c.Handler.ServeHTTP(rw, r)
+	hw.finish()
+}
+
+func (c *CookiePolicy) harden(sc *setCookie, secure bool, now time.Time) (changes []string) {
+	set := func(name, value string, hasValue bool) {
+		if sc.set(name, value, hasValue) {
+			if hasValue {
+				changes = append(changes, "set "+name+"="+value)
+			} else {
+				changes = append(changes, "added "+name)
+			}
+		}
+	}
+
+	hostPrefix := strings.HasPrefix(sc.name, "__Host-")
+
+	needsSecure := (c.Secure && secure) ||
+		hostPrefix || strings.HasPrefix(sc.name, "__Secure-")
+
+	if c.SameSite != "" && sc.get("SameSite") < 0 {
+		set("SameSite", string(c.SameSite), true)
+	}
+
+	if i := sc.get("SameSite"); i >= 0 && strings.EqualFold(sc.attrs[i].value, string(SameSiteNone)) {
+		needsSecure = true
+	}
+
+	if needsSecure {
+		set("Secure", "", false)
+	}
+
+	if c.HttpOnly {
+		set("HttpOnly", "", false)
+	}
+
+	if hostPrefix {
+		if sc.remove("Domain") {
+			changes = append(changes, "removed Domain")
+		}
+
+		set("Path", "/", true)
+	}
+
+	if c.MaxAge > 0 {
+		maxAge := int64(c.MaxAge / time.Second)
+
+		if i := sc.get("Max-Age"); i >= 0 {
+			if v, err := strconv.ParseInt(sc.attrs[i].value, 10, 64); err == nil && v > maxAge {
+				set("Max-Age", strconv.FormatInt(maxAge, 10), true)
+			}
+		} else if i := sc.get("Expires"); i >= 0 {
+			// Max-Age takes precedence over Expires.
+			if exp, err := http.ParseTime(sc.attrs[i].value); err == nil && exp.After(now.Add(c.MaxAge)) {
+				set("Max-Age", strconv.FormatInt(maxAge, 10), true)
+			}
+		}
+	}
+
+	return changes
+}
+
+type setCookieAttr struct {
+	name, value string
+	hasValue    bool
+}
+
+// setCookie is a parsed Set-Cookie header that preserves
+// all attributes, including those unknown to net/http.
+type setCookie struct {
+	name, pair string
+	attrs      []setCookieAttr
+}
+
+func parseSetCookie(line string) *setCookie {
+	parts := strings.Split(line, ";")
+
+	sc := &setCookie{pair: strings.TrimSpace(parts[0])}
+	sc.name = sc.pair
+	if i := strings.IndexByte(sc.pair, '='); i >= 0 {
+		sc.name = strings.TrimSpace(sc.pair[:i])
+	}
+
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var attr setCookieAttr
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			attr = setCookieAttr{strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:]), true}
+		} else {
+			attr = setCookieAttr{part, "", false}
+		}
+
+		sc.attrs = append(sc.attrs, attr)
+	}
+
+	return sc
+}
+
+// get returns the index of the last attribute with the
+// given name, or -1.
+func (sc *setCookie) get(name string) int {
+	for i := len(sc.attrs) - 1; i >= 0; i-- {
+		if strings.EqualFold(sc.attrs[i].name, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// set sets the named attribute and reports whether the
+// cookie was changed.
+func (sc *setCookie) set(name, value string, hasValue bool) bool {
+	attr := setCookieAttr{name, value, hasValue}
+
+	i := sc.get(name)
+	if i < 0 {
+		sc.attrs = append(sc.attrs, attr)
+		return true
+	}
+
+	if sc.attrs[i].hasValue == hasValue && sc.attrs[i].value == value {
+		return false
+	}
+
+	sc.attrs[i] = attr
+	return true
+}
+
+// remove removes all attributes with the given name and
+// reports whether the cookie was changed.
+func (sc *setCookie) remove(name string) bool {
+	attrs := sc.attrs[:0]
+	for _, attr := range sc.attrs {
+		if !strings.EqualFold(attr.name, name) {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	changed := len(attrs) != len(sc.attrs)
+	sc.attrs = attrs
+	return changed
+}
+
+func (sc *setCookie) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(sc.pair)
+
+	for _, attr := range sc.attrs {
+		buf.WriteString("; ")
+		buf.WriteString(attr.name)
+
+		if attr.hasValue {
+			buf.WriteByte('=')
+			buf.WriteString(attr.value)
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookiePolicy(t *testing.T) {
+	var buf bytes.Buffer
+	h := CookiePolicyWrap(&CookiePolicy{
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteLax,
+		MaxAge:   24 * time.Hour,
+
+		Log: &buf,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=abc")
+		w.Header().Add("Set-Cookie", "pref=dark; Path=/; Max-Age=31536000; SameSite=Strict; Partitioned")
+		w.Header().Add("Set-Cookie", "__Host-id=1; Domain=example.com; Path=/app; Expires=Wed, 21 Oct 2099 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "ok=1; Secure; HttpOnly; SameSite=Lax; Max-Age=60")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/login?next=/", nil))
+
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, []string{
+		"session=abc; SameSite=Lax; Secure; HttpOnly",
+		"pref=dark; Path=/; Max-Age=86400; SameSite=Strict; Partitioned; Secure; HttpOnly",
+		"__Host-id=1; Path=/; Expires=Wed, 21 Oct 2099 07:28:00 GMT; SameSite=Lax; Secure; HttpOnly; Max-Age=86400",
+		"ok=1; Secure; HttpOnly; SameSite=Lax; Max-Age=60",
+	}, w.Header()["Set-Cookie"])
+	assert.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d GET /login\?next=/ rewrote cookie "session": set SameSite=Lax, added Secure, added HttpOnly
+.* rewrote cookie "pref": added Secure, added HttpOnly, set Max-Age=86400
+.* rewrote cookie "__Host-id": set SameSite=Lax, added Secure, added HttpOnly, removed Domain, set Path=/, set Max-Age=86400
+$`, buf.String())
+}
+
+func TestCookiePolicyInsecure(t *testing.T) {
+	var buf bytes.Buffer
+	h := &CookiePolicy{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Set-Cookie", "a=1")
+			w.Header().Add("Set-Cookie", "__Secure-b=2")
+			w.Header().Add("Set-Cookie", "c=3; SameSite=None")
+		}),
+		Secure: true,
+
+		Log: &buf,
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, []string{
+		"a=1",
+		"__Secure-b=2; Secure",
+		"c=3; SameSite=None; Secure",
+	}, w.Header()["Set-Cookie"])
+
+	for _, c := range []*CookiePolicy{
+		{SameSite: "lax"},
+		{MaxAge: -time.Second},
+	} {
+		assert.Error(t, c.Validate())
+		assert.Panics(t, func() {
+			CookiePolicyWrap(c)
+		})
+	}
+}
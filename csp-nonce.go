@@ -0,0 +1,134 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// CSPNoncePlaceholder is replaced with the per-request
+// nonce in the Content-Security-Policy when
+// SecurityHeaders.CSPNonce is true.
+const CSPNoncePlaceholder = "{nonce}"
+
+// CSPRequestNonce is a source expression that is replaced
+// with a 'nonce-...' source expression containing the
+// per-request nonce when SecurityHeaders.CSPNonce is
+// true.
+const CSPRequestNonce CSPSource = "'nonce-" + CSPNoncePlaceholder + "'"
+
+type cspNonceKey struct{}
+
+func newCSPNonce() string {
+	var b [18]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func withCSPNonce(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, newCSPNonce()))
+}
+
+func replaceCSPNonce(r *http.Request, policy string) string {
+	return strings.Replace(policy, CSPNoncePlaceholder, CSPNonceFromContext(r.Context()), -1)
+}
+
+// CSPNonceFromContext returns the per-request
+// Content-Security-Policy nonce generated by
+// SecurityHeaders. It returns an empty string if no
+// nonce was generated.
+func CSPNonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// CSPNonceFuncs returns a template.FuncMap for use with
+// html/template that contains two functions:
+//  - cspNonce, which returns the nonce of r, and
+//  - cspNonceAttr, which returns a nonce="..."
+//    attribute for the nonce of r, or nothing if r
+//    has no nonce.
+//
+// As html/template requires functions to be defined
+// when parsing, templates should be parsed with
+// CSPNonceFuncs(nil) and then cloned with
+// CSPNonceFuncs(r) for each request. ServeNonceTemplate
+// does this automatically.
+func CSPNonceFuncs(r *http.Request) template.FuncMap {
+	var nonce string
+	if r != nil {
+		nonce = CSPNonceFromContext(r.Context())
+	}
+
+	return template.FuncMap{
+		"cspNonce": func() string {
+			return nonce
+		},
+		"cspNonceAttr": func() template.HTMLAttr {
+			if nonce == "" {
+				return ""
+			}
+
+			return template.HTMLAttr(`nonce="` + nonce + `"`)
+		},
+	}
+}
+
+// ServeNonceTemplate returns a http.Handler that executes
+// the html/template for each request, with the functions
+// from CSPNonceFuncs bound to the request, and serves the
+// result.
+//
+// The template must have been parsed with
+// CSPNonceFuncs(nil) in its function map.
+//
+// As the response contains a per-request nonce, it is
+// not cacheable and is served with
+// Cache-Control: no-store.
+func ServeNonceTemplate(tmpl *template.Template, data interface{}) Handler {
+	return &serveNonceTemplate{tmpl, data}
+}
+
+type serveNonceTemplate struct {
+	tmpl *template.Template
+	data interface{}
+}
+
+func (st *serveNonceTemplate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := st.tmpl.Clone()
+	if err != nil {
+		http.Error(w, internalServerErrorText, http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Funcs(CSPNonceFuncs(r)).Execute(&buf, st.data); err != nil {
+		http.Error(w, internalServerErrorText, http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+
+	if _, hasType := h["Content-Type"]; !hasType {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+var internalServerErrorText = http.StatusText(http.StatusInternalServerError)
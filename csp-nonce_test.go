@@ -0,0 +1,88 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeadersCSPNonce(t *testing.T) {
+	var nonce string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonceFromContext(r.Context())
+	})
+
+	sh := &SecurityHeaders{
+		Handler: h,
+
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'",
+		CSPNonce:              true,
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	require.NotEmpty(t, nonce)
+	assert.Equal(t, "script-src 'nonce-"+nonce+"'; style-src 'nonce-"+nonce+"'",
+		w.Header().Get("Content-Security-Policy"))
+
+	first := nonce
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	assert.NotEqual(t, first, nonce, "nonce reused between requests")
+
+	sh = &SecurityHeaders{
+		Handler: h,
+
+		CSP:      &CSP{ScriptSrc: []CSPSource{CSPRequestNonce, CSPStrictDynamic}},
+		CSPNonce: true,
+	}
+	require.NoError(t, sh.CSP.Validate())
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	assert.Equal(t, "script-src 'nonce-"+nonce+"' 'strict-dynamic'",
+		w.Header().Get("Content-Security-Policy"))
+}
+
+func TestCSPNonceFromContext(t *testing.T) {
+	assert.Equal(t, "", CSPNonceFromContext(context.Background()))
+}
+
+func TestServeNonceTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(CSPNonceFuncs(nil)).Parse(
+		`<p>{{.}}</p><script {{cspNonceAttr}}></script><style nonce="{{cspNonce}}"></style>`))
+
+	var h http.Handler = ServeNonceTemplate(tmpl, "x")
+	h = &SecurityHeaders{Handler: h, CSPNonce: true}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^<p>x</p><script nonce="[A-Za-z0-9_-]{24}"></script><style nonce="[A-Za-z0-9_-]{24}"></style>$`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	ServeNonceTemplate(tmpl, "x").ServeHTTP(w, r)
+
+	assert.Equal(t, `<p>x</p><script ></script><style nonce=""></style>`, w.Body.String())
+}
@@ -0,0 +1,375 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CSPSource is a source expression for use in a
+// Content-Security-Policy directive.
+type CSPSource string
+
+// The keyword source expressions.
+const (
+	CSPSelf                 CSPSource = "'self'"
+	CSPNone                 CSPSource = "'none'"
+	CSPUnsafeInline         CSPSource = "'unsafe-inline'"
+	CSPUnsafeEval           CSPSource = "'unsafe-eval'"
+	CSPUnsafeHashes         CSPSource = "'unsafe-hashes'"
+	CSPStrictDynamic        CSPSource = "'strict-dynamic'"
+	CSPReportSample         CSPSource = "'report-sample'"
+	CSPWasmUnsafeEval       CSPSource = "'wasm-unsafe-eval'"
+	CSPUnsafeAllowRedirects CSPSource = "'unsafe-allow-redirects'"
+)
+
+// CSPNonce returns a 'nonce-...' source expression for
+// the given base64 encoded nonce.
+func CSPNonce(nonce string) CSPSource {
+	return CSPSource("'nonce-" + nonce + "'")
+}
+
+// CSPHash returns a hash source expression for the given
+// algorithm, one of sha256, sha384 or sha512, and base64
+// encoded digest.
+func CSPHash(algo, digest string) CSPSource {
+	return CSPSource("'" + algo + "-" + digest + "'")
+}
+
+// CSPSHA256 returns a 'sha256-...' source expression
+// for the given inline script or style content.
+func CSPSHA256(content []byte) CSPSource {
+	sum := sha256.Sum256(content)
+	return CSPHash("sha256", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// CSPSHA384 returns a 'sha384-...' source expression
+// for the given inline script or style content.
+func CSPSHA384(content []byte) CSPSource {
+	sum := sha512.Sum384(content)
+	return CSPHash("sha384", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// CSPSHA512 returns a 'sha512-...' source expression
+// for the given inline script or style content.
+func CSPSHA512(content []byte) CSPSource {
+	sum := sha512.Sum512(content)
+	return CSPHash("sha512", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// CSPScheme returns a scheme source expression, such as
+// https:, for the given scheme.
+func CSPScheme(scheme string) CSPSource {
+	return CSPSource(strings.TrimSuffix(scheme, ":") + ":")
+}
+
+// CSPHost returns a host source expression, such as
+// https://*.example.com:443/path.
+func CSPHost(host string) CSPSource {
+	return CSPSource(host)
+}
+
+// CSP is a typed Content-Security-Policy.
+//
+// Each directive is omitted from the serialized policy
+// if its source list is empty.
+//
+// See https://www.w3.org/TR/CSP3/ for the meaning of each
+// directive.
+type CSP struct {
+	// Fetch directives.
+	DefaultSrc    []CSPSource
+	ChildSrc      []CSPSource
+	ConnectSrc    []CSPSource
+	FontSrc       []CSPSource
+	FrameSrc      []CSPSource
+	ImgSrc        []CSPSource
+	ManifestSrc   []CSPSource
+	MediaSrc      []CSPSource
+	ObjectSrc     []CSPSource
+	PrefetchSrc   []CSPSource
+	ScriptSrc     []CSPSource
+	ScriptSrcElem []CSPSource
+	ScriptSrcAttr []CSPSource
+	StyleSrc      []CSPSource
+	StyleSrcElem  []CSPSource
+	StyleSrcAttr  []CSPSource
+	WorkerSrc     []CSPSource
+
+	// Document directives.
+	BaseURI []CSPSource
+
+	// Sandbox enables the sandbox directive. The
+	// directive is also enabled if SandboxFlags is
+	// not empty.
+	Sandbox bool
+
+	// SandboxFlags lists the allow-* flags of the
+	// sandbox directive, for example allow-scripts.
+	SandboxFlags []string
+
+	// Navigation directives.
+	FormAction     []CSPSource
+	FrameAncestors []CSPSource
+	NavigateTo     []CSPSource
+
+	// Reporting directives.
+	ReportURI []string
+	ReportTo  string
+
+	// Other directives.
+	UpgradeInsecureRequests bool
+	BlockAllMixedContent    bool
+}
+
+func (c *CSP) sourceLists() []struct {
+	name string
+	srcs *[]CSPSource
+} {
+	return []struct {
+		name string
+		srcs *[]CSPSource
+	}{
+		{"default-src", &c.DefaultSrc},
+		{"child-src", &c.ChildSrc},
+		{"connect-src", &c.ConnectSrc},
+		{"font-src", &c.FontSrc},
+		{"frame-src", &c.FrameSrc},
+		{"img-src", &c.ImgSrc},
+		{"manifest-src", &c.ManifestSrc},
+		{"media-src", &c.MediaSrc},
+		{"object-src", &c.ObjectSrc},
+		{"prefetch-src", &c.PrefetchSrc},
+		{"script-src", &c.ScriptSrc},
+		{"script-src-elem", &c.ScriptSrcElem},
+		{"script-src-attr", &c.ScriptSrcAttr},
+		{"style-src", &c.StyleSrc},
+		{"style-src-elem", &c.StyleSrcElem},
+		{"style-src-attr", &c.StyleSrcAttr},
+		{"worker-src", &c.WorkerSrc},
+		{"base-uri", &c.BaseURI},
+		{"form-action", &c.FormAction},
+		{"frame-ancestors", &c.FrameAncestors},
+		{"navigate-to", &c.NavigateTo},
+	}
+}
+
+// String returns the serialized policy suitable for use
+// in a Content-Security-Policy header.
+//
+// Directives are always serialized in the same order.
+func (c *CSP) String() string {
+	var directives []string
+
+	for _, sl := range c.sourceLists() {
+		if len(*sl.srcs) == 0 {
+			continue
+		}
+
+		parts := make([]string, 1, 1+len(*sl.srcs))
+		parts[0] = sl.name
+
+		for _, src := range *sl.srcs {
+			parts = append(parts, string(src))
+		}
+
+		directives = append(directives, strings.Join(parts, " "))
+	}
+
+	if c.Sandbox || len(c.SandboxFlags) != 0 {
+		directives = append(directives, strings.Join(append([]string{"sandbox"}, c.SandboxFlags...), " "))
+	}
+
+	if c.UpgradeInsecureRequests {
+		directives = append(directives, "upgrade-insecure-requests")
+	}
+
+	if c.BlockAllMixedContent {
+		directives = append(directives, "block-all-mixed-content")
+	}
+
+	if len(c.ReportURI) != 0 {
+		directives = append(directives, strings.Join(append([]string{"report-uri"}, c.ReportURI...), " "))
+	}
+
+	if c.ReportTo != "" {
+		directives = append(directives, "report-to "+c.ReportTo)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+var (
+	cspSchemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:$`)
+	cspHostRe   = regexp.MustCompile(`^(?:[a-zA-Z][a-zA-Z0-9+.-]*://)?(?:\*|(?:\*\.)?[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)(?::(?:[0-9]+|\*))?(?:/[^\s;,']*)?$`)
+	cspNonceRe  = regexp.MustCompile(`^'nonce-[a-zA-Z0-9+/_-]+={0,2}'$`)
+	cspHashRe   = regexp.MustCompile(`^'sha(?:256|384|512)-[a-zA-Z0-9+/_-]+={0,2}'$`)
+	cspTokenRe  = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+)
+
+var cspKeywords = map[CSPSource]bool{
+	CSPSelf:                 true,
+	CSPNone:                 true,
+	CSPUnsafeInline:         true,
+	CSPUnsafeEval:           true,
+	CSPUnsafeHashes:         true,
+	CSPStrictDynamic:        true,
+	CSPReportSample:         true,
+	CSPWasmUnsafeEval:       true,
+	CSPUnsafeAllowRedirects: true,
+}
+
+var cspSandboxFlags = map[string]bool{
+	"allow-downloads":                          true,
+	"allow-forms":                              true,
+	"allow-modals":                             true,
+	"allow-orientation-lock":                   true,
+	"allow-pointer-lock":                       true,
+	"allow-popups":                             true,
+	"allow-popups-to-escape-sandbox":           true,
+	"allow-presentation":                       true,
+	"allow-same-origin":                        true,
+	"allow-scripts":                            true,
+	"allow-storage-access-by-user-activation":  true,
+	"allow-top-navigation":                     true,
+	"allow-top-navigation-by-user-activation":  true,
+	"allow-top-navigation-to-custom-protocols": true,
+}
+
+func validCSPSource(src CSPSource) bool {
+	s := string(src)
+
+	if strings.HasPrefix(s, "'") {
+		return cspKeywords[CSPSource(strings.ToLower(s))] ||
+			src == CSPRequestNonce ||
+			cspNonceRe.MatchString(s) ||
+			cspHashRe.MatchString(s)
+	}
+
+	// A keyword without quotes, like self, is a valid
+	// host-source but is almost certainly a mistake.
+	if cspKeywords[CSPSource("'"+strings.ToLower(s)+"'")] {
+		return false
+	}
+
+	return cspSchemeRe.MatchString(s) || cspHostRe.MatchString(s)
+}
+
+// Validate returns an error if the policy contains a
+// malformed source expression, an unquoted keyword, if
+// 'none' is combined with other sources or if an unknown
+// sandbox flag is used.
+func (c *CSP) Validate() error {
+	for _, sl := range c.sourceLists() {
+		for _, src := range *sl.srcs {
+			if !validCSPSource(src) {
+				return fmt.Errorf("handlers: invalid source %q in %s directive", src, sl.name)
+			}
+
+			if strings.EqualFold(string(src), string(CSPNone)) && len(*sl.srcs) != 1 {
+				return fmt.Errorf("handlers: 'none' must be the only source in %s directive", sl.name)
+			}
+		}
+	}
+
+	for _, flag := range c.SandboxFlags {
+		if !cspSandboxFlags[strings.ToLower(flag)] {
+			return fmt.Errorf("handlers: invalid sandbox flag %q", flag)
+		}
+	}
+
+	for _, uri := range c.ReportURI {
+		if _, err := parseURL(uri); err != nil || uri == "" || strings.ContainsAny(uri, " ;,") {
+			return fmt.Errorf("handlers: invalid report-uri %q", uri)
+		}
+	}
+
+	if c.ReportTo != "" && !cspTokenRe.MatchString(c.ReportTo) {
+		return fmt.Errorf("handlers: invalid report-to group %q", c.ReportTo)
+	}
+
+	return nil
+}
+
+// ParseCSP parses a serialized Content-Security-Policy
+// into a CSP.
+//
+// Unlike browsers, which silently ignore them, it
+// returns an error for unknown or duplicate directives
+// and for any policy that fails Validate.
+func ParseCSP(policy string) (*CSP, error) {
+	c := new(CSP)
+
+	lists := make(map[string]*[]CSPSource)
+	for _, sl := range c.sourceLists() {
+		lists[sl.name] = sl.srcs
+	}
+
+	seen := make(map[string]bool)
+
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name, values := strings.ToLower(fields[0]), fields[1:]
+
+		if seen[name] {
+			return nil, fmt.Errorf("handlers: duplicate %s directive", name)
+		}
+		seen[name] = true
+
+		if srcs, ok := lists[name]; ok {
+			if len(values) == 0 {
+				return nil, fmt.Errorf("handlers: empty %s directive", name)
+			}
+
+			for _, v := range values {
+				*srcs = append(*srcs, CSPSource(v))
+			}
+
+			continue
+		}
+
+		switch name {
+		case "sandbox":
+			c.Sandbox = true
+			c.SandboxFlags = values
+		case "upgrade-insecure-requests", "block-all-mixed-content":
+			if len(values) != 0 {
+				return nil, fmt.Errorf("handlers: %s directive takes no value", name)
+			}
+
+			if name == "upgrade-insecure-requests" {
+				c.UpgradeInsecureRequests = true
+			} else {
+				c.BlockAllMixedContent = true
+			}
+		case "report-uri":
+			c.ReportURI = values
+		case "report-to":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("handlers: report-to directive must have exactly one group")
+			}
+
+			c.ReportTo = values[0]
+		default:
+			return nil, fmt.Errorf("handlers: unknown directive %q", name)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSPString(t *testing.T) {
+	c := &CSP{
+		ReportTo:       "csp",
+		ScriptSrc:      []CSPSource{CSPSelf, CSPNonce("abc123=="), CSPSHA256([]byte("alert(1)"))},
+		DefaultSrc:     []CSPSource{CSPNone},
+		ImgSrc:         []CSPSource{CSPSelf, CSPScheme("data"), CSPHost("https://*.example.com")},
+		FrameAncestors: []CSPSource{CSPNone},
+		SandboxFlags:   []string{"allow-scripts"},
+
+		UpgradeInsecureRequests: true,
+
+		ReportURI: []string{"/csp-report"},
+	}
+
+	assert.Equal(t, "default-src 'none'; "+
+		"img-src 'self' data: https://*.example.com; "+
+		"script-src 'self' 'nonce-abc123==' 'sha256-bhHHL3z2vDgxUt0W3dWQOrprscmda2Y5pLsLg4GF+pI='; "+
+		"frame-ancestors 'none'; "+
+		"sandbox allow-scripts; "+
+		"upgrade-insecure-requests; "+
+		"report-uri /csp-report; "+
+		"report-to csp", c.String())
+	assert.NoError(t, c.Validate())
+
+	assert.Equal(t, "", new(CSP).String())
+	assert.Equal(t, "sandbox", (&CSP{Sandbox: true}).String())
+}
+
+func TestCSPValidate(t *testing.T) {
+	for _, c := range []*CSP{
+		{DefaultSrc: []CSPSource{"self"}},
+		{DefaultSrc: []CSPSource{"'unsafe-inlin'"}},
+		{DefaultSrc: []CSPSource{CSPNone, CSPSelf}},
+		{ScriptSrc: []CSPSource{"'nonce-'"}},
+		{ScriptSrc: []CSPSource{"'sha1-abcd'"}},
+		{ImgSrc: []CSPSource{"https://exa mple.com"}},
+		{ImgSrc: []CSPSource{"example.*.com"}},
+		{SandboxFlags: []string{"allow-everything"}},
+		{ReportURI: []string{""}},
+		{ReportTo: "bad group"},
+	} {
+		assert.Error(t, c.Validate(), "%s", c)
+	}
+
+	for _, c := range []*CSP{
+		{DefaultSrc: []CSPSource{"'SELF'"}},
+		{DefaultSrc: []CSPSource{"*"}},
+		{ImgSrc: []CSPSource{"example.com", "*.example.com:*", "https://example.com:8443/path/"}},
+		{ScriptSrc: []CSPSource{CSPSHA384(nil), CSPSHA512(nil), CSPStrictDynamic}},
+	} {
+		assert.NoError(t, c.Validate(), "%s", c)
+	}
+}
+
+func TestParseCSP(t *testing.T) {
+	const policy = "default-src 'none'; script-src 'self' https://cdn.example.com; " +
+		"sandbox allow-forms allow-scripts; upgrade-insecure-requests; report-uri /report"
+
+	c, err := ParseCSP(policy)
+	require.NoError(t, err)
+
+	assert.Equal(t, &CSP{
+		DefaultSrc:              []CSPSource{CSPNone},
+		ScriptSrc:               []CSPSource{CSPSelf, "https://cdn.example.com"},
+		Sandbox:                 true,
+		SandboxFlags:            []string{"allow-forms", "allow-scripts"},
+		UpgradeInsecureRequests: true,
+		ReportURI:               []string{"/report"},
+	}, c)
+	assert.Equal(t, policy, c.String())
+
+	for _, policy := range []string{
+		"default-scr 'self'",
+		"default-src 'self'; default-src 'none'",
+		"script-src",
+		"script-src self",
+		"upgrade-insecure-requests 1",
+		"report-to a b",
+	} {
+		_, err := ParseCSP(policy)
+		assert.Error(t, err, "%q", policy)
+	}
+}
+
+func TestSecurityHeadersCSP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+
+		ContentSecurityPolicy: "fail",
+		CSP:                   &CSP{DefaultSrc: []CSPSource{CSPSelf}},
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			CSP: &CSP{DefaultSrc: []CSPSource{"self"}},
+		})(h)
+	})
+}
+
+func TestSecurityHeadersCSPReportOnly(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+
+		ContentSecurityPolicy:           "default-src 'self'",
+		ContentSecurityPolicyReportOnly: "default-src 'none'; report-uri /csp",
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "default-src 'none'; report-uri /csp", w.Header().Get("Content-Security-Policy-Report-Only"))
+
+	w = httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+
+		ContentSecurityPolicyReportOnly: "fail",
+		CSPReportOnly: &CSP{
+			ScriptSrc: []CSPSource{CSPRequestNonce},
+			ReportTo:  "csp",
+		},
+		CSPNonce: true,
+	}).ServeHTTP(w, r)
+
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Regexp(t, `^script-src 'nonce-[A-Za-z0-9_-]{24}'; report-to csp$`, w.Header().Get("Content-Security-Policy-Report-Only"))
+}
+
+func TestSecurityHeadersCSPReportOnlySampleRate(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	sh := &SecurityHeaders{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+
+		ContentSecurityPolicy:           "default-src 'self'",
+		ContentSecurityPolicyReportOnly: "default-src 'none'",
+		CSPReportOnlySampleRate:         0.25,
+	}
+
+	const n = 4000
+
+	var sampled int
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, r)
+
+		assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+
+		if w.Header().Get("Content-Security-Policy-Report-Only") != "" {
+			sampled++
+		}
+	}
+
+	assert.InDelta(t, n/4, sampled, n/20)
+
+	for _, rate := range []float64{-0.1, 1.1} {
+		assert.Error(t, (&SecurityHeaders{CSPReportOnlySampleRate: rate}).Validate())
+	}
+
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			CSPReportOnly: &CSP{DefaultSrc: []CSPSource{"none"}},
+		})
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// DefaultErrorPageTemplate is the html/template used by
+// ErrorPages when no Template is provided. It is
+// executed with an ErrorPageData.
+var DefaultErrorPageTemplate = template.Must(template.New("error-page").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Code}} {{.Status}}{{with .Brand}} - {{.}}{{end}}</title>
+<style>
+body{margin:0;padding:4em 1em;font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Helvetica,Arial,sans-serif;color:#333;background:#f7f7f7;text-align:center}
+h1{margin:0 0 .25em;font-size:4em;font-weight:300;color:#777}
+h2{margin:0 0 1em;font-weight:400}
+p{max-width:36em;margin:0 auto 1em;line-height:1.5}
+footer{margin-top:3em;font-size:.85em;color:#999}
+{{.CSS}}
+</style>
+</head>
+<body>
+<h1>{{.Code}}</h1>
+<h2>{{.Status}}</h2>
+{{with .Message}}<p>{{.}}</p>
+{{end}}{{with .Brand}}<footer>{{.}}</footer>
+{{end}}</body>
+</html>
+`))
+
+// ErrorPageData is the data passed to the template
+// when rendering each error page.
+type ErrorPageData struct {
+	// The HTTP status code of the page.
+	Code int
+
+	// The http.StatusText of Code.
+	Status string
+
+	// The per-code message from ErrorPages.Messages,
+	// if any.
+	Message string
+
+	// The branding text from ErrorPages.Brand.
+	Brand string
+
+	// The additional CSS from ErrorPages.CSS.
+	CSS template.CSS
+}
+
+// ErrorPages renders a set of error pages, one for each
+// standard 4xx and 5xx HTTP status code, for use with
+// StatusCodeSwitch.
+type ErrorPages struct {
+	// The template to execute for each page. It is
+	// executed with an ErrorPageData.
+	//
+	// If Template is nil, DefaultErrorPageTemplate
+	// is used.
+	Template Template
+
+	// Optionally specifies branding text, such
+	// as a site name, to include in each page.
+	Brand string
+
+	// Optionally specifies additional CSS to
+	// include in each page.
+	CSS template.CSS
+
+	// Optionally specifies a message to include
+	// in the page for a given HTTP status code.
+	Messages map[int]string
+}
+
+// Handlers returns a map of HTTP status code to a
+// http.Handler that serves the rendered error page with
+// that status code. It covers every 4xx and 5xx status
+// code known to http.StatusText.
+//
+// The returned map can be passed directly to
+// StatusCodeSwitch.
+func (ep *ErrorPages) Handlers() (map[int]http.Handler, error) {
+	handlers := make(map[int]http.Handler)
+
+	for code := 400; code < 600; code++ {
+		if http.StatusText(code) == "" {
+			continue
+		}
+
+		h, err := ep.Handler(code)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers[code] = h
+	}
+
+	return handlers, nil
+}
+
+// Handler returns a http.Handler that serves the
+// rendered error page for a single HTTP status code.
+func (ep *ErrorPages) Handler(code int) (Handler, error) {
+	var tmpl Template = DefaultErrorPageTemplate
+	if ep.Template != nil {
+		tmpl = ep.Template
+	}
+
+	return ServeErrorTemplate(code, tmpl, &ErrorPageData{
+		Code:    code,
+		Status:  http.StatusText(code),
+		Message: ep.Messages[code],
+		Brand:   ep.Brand,
+		CSS:     ep.CSS,
+	}, "text/html; charset=utf-8")
+}
+
+// DefaultErrorPages returns the result of
+// (*ErrorPages).Handlers for the default error pages.
+func DefaultErrorPages() (map[int]http.Handler, error) {
+	return new(ErrorPages).Handlers()
+}
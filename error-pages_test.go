@@ -0,0 +1,90 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultErrorPages(t *testing.T) {
+	handlers, err := DefaultErrorPages()
+	require.NoError(t, err)
+
+	for _, code := range []int{
+		http.StatusBadRequest,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+	} {
+		require.Contains(t, handlers, code)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handlers[code].ServeHTTP(w, r)
+
+		assert.Equal(t, code, w.Code)
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), http.StatusText(code))
+	}
+
+	assert.NotContains(t, handlers, http.StatusOK)
+	assert.NotContains(t, handlers, http.StatusMovedPermanently)
+	assert.NotContains(t, handlers, 499)
+}
+
+func TestErrorPagesOverrides(t *testing.T) {
+	ep := &ErrorPages{
+		Brand: "Example & Co",
+		CSS:   template.CSS("body{color:red}"),
+		Messages: map[int]string{
+			http.StatusNotFound: "The page <you> requested does not exist.",
+		},
+	}
+
+	h, err := ep.Handler(http.StatusNotFound)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Example &amp; Co")
+	assert.Contains(t, w.Body.String(), "body{color:red}")
+	assert.Contains(t, w.Body.String(), "The page &lt;you&gt; requested does not exist.")
+
+	h, err = ep.Handler(http.StatusBadGateway)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.NotContains(t, w.Body.String(), "does not exist")
+}
+
+func TestErrorPagesTemplate(t *testing.T) {
+	ep := &ErrorPages{
+		Template: template.Must(template.New("").Parse(`{{.Code}}:{{.Status}}`)),
+	}
+
+	handlers, err := ep.Handlers()
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handlers[http.StatusNotFound].ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "404:Not Found", w.Body.String())
+}
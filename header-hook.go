@@ -0,0 +1,205 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// hookResponseWriter calls hook exactly once, immediately
+// before the response headers are written, so that the
+// final response headers can be inspected and modified.
+type hookResponseWriter struct {
+	http.ResponseWriter
+
+	hook func(code int, sniff []byte)
+	done bool
+}
+
+// newHookResponseWriter returns a http.ResponseWriter that
+// calls hook before the response headers are written. It
+// preserves the http.CloseNotifier, http.Hijacker and
+// http.Pusher interfaces of w, forwards io.ReaderFrom to w
+// once the hook has been called, and implements Unwrap for
+// http.ResponseController.
+//
+// The returned *hookResponseWriter's finish method must be
+// called after the http.Handler returns.
+func newHookResponseWriter(w http.ResponseWriter, hook func(code int, sniff []byte)) (http.ResponseWriter, *hookResponseWriter) {
+	hw := &hookResponseWriter{
+		ResponseWriter: w,
+		hook:           hook,
+	}
+
+	var rw http.ResponseWriter = hw
+
+	_, cok := w.(http.CloseNotifier)
+	_, hok := w.(http.Hijacker)
+	_, pok := w.(http.Pusher)
+
+	switch {
+	case cok && hok:
+		rw = closeNotifyHijackHookResponseWriter{hw}
+	case cok && pok:
+		rw = closeNotifyPusherHookResponseWriter{hw}
+	case cok:
+		rw = closeNotifyHookResponseWriter{hw}
+	case hok:
+		rw = hijackHookResponseWriter{hw}
+	case pok:
+		rw = pusherHookResponseWriter{hw}
+	}
+
+	return rw, hw
+}
+
+func (w *hookResponseWriter) runHook(code int, sniff []byte) {
+	if w.done {
+		return
+	}
+
+	w.done = true
+	w.hook(code, sniff)
+}
+
+// finish calls the hook if the http.Handler returned
+// without writing a response.
+func (w *hookResponseWriter) finish() {
+	w.runHook(http.StatusOK, nil)
+}
+
+func (w *hookResponseWriter) WriteHeader(code int) {
+	// Informational responses, other than 101 Switching
+	// Protocols, are followed by the final response.
+	if code < 100 || code > 199 || code == http.StatusSwitchingProtocols {
+		w.runHook(code, nil)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *hookResponseWriter) Write(p []byte) (int, error) {
+	w.runHook(http.StatusOK, p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *hookResponseWriter) WriteString(s string) (int, error) {
+	if !w.done {
+		w.runHook(http.StatusOK, []byte(s))
+	}
+
+	return io.WriteString(w.ResponseWriter, s)
+}
+
+// ReadFrom implements io.ReaderFrom so that the sendfile
+// fast path of the underlying http.ResponseWriter is not
+// lost. The first part of src is passed to Write so that
+// the hook is called with it.
+func (w *hookResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var written int64
+
+	if !w.done {
+		var buf [512]byte
+
+		n, err := io.ReadFull(src, buf[:])
+		if n > 0 {
+			nw, werr := w.Write(buf[:n])
+			written = int64(nw)
+
+			if werr != nil {
+				return written, werr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return written, nil
+		default:
+			return written, err
+		}
+	}
+
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		return written + n, err
+	}
+
+	// Hide the ReadFrom method of w.ResponseWriter, if
+	// any, to avoid infinite recursion in io.Copy.
+	n, err := io.Copy(struct{ io.Writer }{w.ResponseWriter}, src)
+	return written + n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter for
+// http.ResponseController.
+func (w *hookResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *hookResponseWriter) Flush() {
+	w.runHook(http.StatusOK, nil)
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type (
+	// Each of these structs is intentionally small (1 pointer wide) so
+	// as to fit inside an interface{} without causing an allocaction.
+	closeNotifyHookResponseWriter       struct{ *hookResponseWriter }
+	hijackHookResponseWriter            struct{ *hookResponseWriter }
+	pusherHookResponseWriter            struct{ *hookResponseWriter }
+	closeNotifyHijackHookResponseWriter struct{ *hookResponseWriter }
+	closeNotifyPusherHookResponseWriter struct{ *hookResponseWriter }
+)
+
+var (
+	_ http.CloseNotifier = closeNotifyHookResponseWriter{}
+	_ http.CloseNotifier = closeNotifyHijackHookResponseWriter{}
+	_ http.CloseNotifier = closeNotifyPusherHookResponseWriter{}
+	_ http.Hijacker      = hijackHookResponseWriter{}
+	_ http.Hijacker      = closeNotifyHijackHookResponseWriter{}
+	_ http.Pusher        = pusherHookResponseWriter{}
+	_ http.Pusher        = closeNotifyPusherHookResponseWriter{}
+	_ io.ReaderFrom      = (*hookResponseWriter)(nil)
+)
+
+func (w closeNotifyHookResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w closeNotifyHijackHookResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w closeNotifyPusherHookResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w hijackHookResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// The response headers will never be written
+	// once the connection is hijacked.
+	w.done = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w closeNotifyHijackHookResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.done = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w pusherHookResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (w closeNotifyPusherHookResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeHost returns the canonical form of a hostname:
+// lowercased, without a trailing dot and with any
+// internationalized labels converted to punycode. IP
+// addresses are returned unchanged, other than IPv6
+// addresses being lowercased.
+//
+// Underscores are permitted, as they are common in
+// internal names like Docker Compose service names.
+//
+// It returns an error if host is not a valid hostname.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+
+	if net.ParseIP(host) != nil {
+		return strings.ToLower(host), nil
+	}
+
+	host, err := hostProfile.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+
+	// Without the STD3 rules, the profile accepts any
+	// ASCII character, so restrict hosts to letters,
+	// digits, hyphens and underscores.
+	for i := 0; i < len(host); i++ {
+		switch c := host[i]; {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.':
+		default:
+			return "", fmt.Errorf("invalid character %q", c)
+		}
+	}
+
+	return host, nil
+}
+
+// hostProfile is idna.Lookup without the STD3 rules,
+// which disallow underscores.
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(false))
+
+// normalizeHostPattern is like normalizeHost but also
+// accepts a leading wildcard label.
+func normalizeHostPattern(host string) (string, error) {
+	if !strings.HasPrefix(host, "*.") {
+		return normalizeHost(host)
+	}
+
+	name, err := normalizeHost(host[len("*."):])
+	return "*." + name, err
+}
+
+// requestHost returns the normalized hostname of the
+// request's Host header. If the host is invalid, it is
+// lowercased and returned without further normalization,
+// so that it will not match any valid host.
+func requestHost(r *http.Request) string {
+	return lookupHost((&url.URL{Host: r.Host}).Hostname())
+}
+
+// lookupHost returns the normalized form of host, or host
+// lowercased if it is invalid.
+func lookupHost(host string) string {
+	if norm, err := normalizeHost(host); err == nil {
+		return norm
+	}
+
+	return strings.ToLower(host)
+}
@@ -18,8 +18,12 @@ import (
 //
 // The provided code should be in the 3xx range
 // and is usually http.StatusMovedPermanently.
+//
+// The host is normalized: lowercased, without a
+// trailing dot and with any internationalized
+// labels converted to punycode.
 func HostRedirect(host string, code int) Handler {
-	return &hostRedirector{host, code}
+	return &hostRedirector{lookupHost(host), code}
 }
 
 type hostRedirector struct {
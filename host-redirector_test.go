@@ -35,3 +35,12 @@ func TestHostRedirector(t *testing.T) {
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	assert.Equal(t, "https://example.com:1234/path/to/file", w.Result().Header.Get("Location"))
 }
+
+func TestHostRedirectorNormalize(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://example.org/path/to/file", nil)
+	w := httptest.NewRecorder()
+	HostRedirect("Bücher.Example.", http.StatusSeeOther).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "https://xn--bcher-kva.example/path/to/file", w.Result().Header.Get("Location"))
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type hostParamsKey struct{}
+
+// HostParamsFromContext returns the named segments
+// captured by the HostRouter pattern that matched the
+// request, or nil if the request was not routed by a
+// HostRouter.
+func HostParamsFromContext(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(hostParamsKey{}).(map[string]string)
+	return params
+}
+
+var hostParamNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type hostPattern struct {
+	re *regexp.Regexp
+	h  http.Handler
+}
+
+// HostRouter is a http.Handler that routes the request
+// based on the Host header using patterns with named
+// segments, like {tenant}.{region}.example.com.
+//
+// A segment matches one or more characters within a
+// single label. A segment may instead specify a regular
+// expression, like {id:[0-9]+}, which may match across
+// labels. The captured segments are available from
+// HostParamsFromContext.
+//
+// The Host header is normalized, as with HostSwitch,
+// before being matched, so internationalized labels in
+// patterns must be given in punycode. The patterns are
+// matched in the order they were added.
+//
+// HostRouter may be used as the NotFound handler of a
+// HostSwitch to route hosts that are not matched
+// exactly.
+type HostRouter struct {
+	patterns []hostPattern
+
+	// NotFound is invoked for hosts
+	// that do not match any pattern.
+	NotFound http.Handler
+}
+
+// Add adds a http.Handler to the host router.
+//
+// It panics if the pattern is invalid or has already
+// been added.
+func (hr *HostRouter) Add(pattern string, h http.Handler) {
+	re, err := compileHostPattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, p := range hr.patterns {
+		if p.re.String() == re.String() {
+			panic("handlers: a handle is already registered for host pattern '" + pattern + "'")
+		}
+	}
+
+	hr.patterns = append(hr.patterns, hostPattern{re, h})
+}
+
+func compileHostPattern(pattern string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('^')
+
+	names := make(map[string]bool)
+
+	rest := strings.TrimSuffix(pattern, ".")
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			open = len(rest)
+		}
+
+		literal := strings.ToLower(rest[:open])
+		if strings.ContainsAny(literal, "}*") {
+			return nil, fmt.Errorf("handlers: invalid host pattern %q", pattern)
+		}
+
+		buf.WriteString(regexp.QuoteMeta(literal))
+		rest = rest[open:]
+
+		if rest == "" {
+			break
+		}
+
+		// Find the matching brace, allowing for
+		// braces within a regular expression.
+		end, depth := -1, 0
+		for i := 0; i < len(rest) && end < 0; i++ {
+			switch rest[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("handlers: unterminated segment in host pattern %q", pattern)
+		}
+
+		name, expr := rest[1:end], `[^.]+`
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name, expr = name[:i], name[i+1:]
+		}
+
+		if !hostParamNameRe.MatchString(name) || names[name] || expr == "" {
+			return nil, fmt.Errorf("handlers: invalid segment %q in host pattern %q", rest[:end+1], pattern)
+		}
+
+		names[name] = true
+
+		buf.WriteString("(?P<")
+		buf.WriteString(name)
+		buf.WriteByte('>')
+		buf.WriteString(expr)
+		buf.WriteByte(')')
+
+		rest = rest[end+1:]
+	}
+
+	buf.WriteByte('$')
+
+	if buf.Len() == len("^$") {
+		return nil, fmt.Errorf("handlers: invalid host pattern %q", pattern)
+	}
+
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("handlers: invalid host pattern %q: %v", pattern, err)
+	}
+
+	return re, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (hr *HostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := requestHost(r)
+
+	for _, p := range hr.patterns {
+		match := p.re.FindStringSubmatch(host)
+		if match == nil {
+			continue
+		}
+
+		params := make(map[string]string)
+		for i, name := range p.re.SubexpNames() {
+			if name != "" {
+				params[name] = match[i]
+			}
+		}
+
+		p.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hostParamsKey{}, params)))
+		return
+	}
+
+	if hr.NotFound != nil {
+		hr.NotFound.ServeHTTP(w, r)
+	} else {
+		http.Error(w, forbiddenText, http.StatusForbidden)
+	}
+}
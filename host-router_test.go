@@ -0,0 +1,110 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostRouter(t *testing.T) {
+	var hr HostRouter
+
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+
+			for k, v := range HostParamsFromContext(r.Context()) {
+				w.Header().Set("X-Param-"+k, v)
+			}
+		})
+	}
+
+	hr.Add("{tenant}.{region}.Example.com", handler("tenant"))
+	hr.Add("api-{version:v[0-9]+}.example.com", handler("api"))
+	hr.Add("{sub:.+}.example.org.", handler("any"))
+
+	for _, tc := range []struct {
+		host    string
+		handler string
+		params  map[string]string
+	}{
+		{"acme.eu.example.com", "tenant", map[string]string{"tenant": "acme", "region": "eu"}},
+		{"ACME.EU.example.com.:8443", "tenant", map[string]string{"tenant": "acme", "region": "eu"}},
+		{"api-v2.example.com", "api", map[string]string{"version": "v2"}},
+		{"a.b.c.example.org", "any", map[string]string{"sub": "a.b.c"}},
+		{"x.acme.eu.example.com", "", nil},
+		{"api-beta.example.com", "", nil},
+		{"example.com", "", nil},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+
+		w := httptest.NewRecorder()
+		hr.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.handler, w.Header().Get("X-Handler"), tc.host)
+
+		if tc.handler == "" {
+			assert.Equal(t, http.StatusForbidden, w.Code, tc.host)
+		}
+
+		for k, v := range tc.params {
+			assert.Equal(t, v, w.Header().Get("X-Param-"+k), tc.host)
+		}
+	}
+
+	for _, pattern := range []string{
+		"",
+		"{tenant.example.com",
+		"{tenant}.{tenant}.example.com",
+		"{1abc}.example.com",
+		"{id:}.example.com",
+		"{id:[}.example.com",
+		"*.example.com",
+		"{tenant}.{region}.example.com",
+	} {
+		assert.Panics(t, func() {
+			hr.Add(pattern, handler("invalid"))
+		}, pattern)
+	}
+}
+
+func TestHostRouterHostSwitch(t *testing.T) {
+	hr := &HostRouter{
+		NotFound: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(999)
+		}),
+	}
+	hr.Add("{tenant}.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Tenant", HostParamsFromContext(r.Context())["tenant"])
+		w.WriteHeader(998)
+	}))
+
+	hs := &HostSwitch{NotFound: hr}
+	hs.Add("www.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(997)
+	}))
+
+	for _, tc := range []struct {
+		host   string
+		code   int
+		tenant string
+	}{
+		{"www.example.com", 997, ""},
+		{"acme.example.com", 998, "acme"},
+		{"example.org", 999, ""},
+	} {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/", nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.host)
+		assert.Equal(t, tc.tenant, w.Header().Get("X-Tenant"), tc.host)
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type hostWildcardKey struct{}
+
+// HostWildcardFromContext returns the labels matched by
+// the wildcard of a *.example.com host switch entry, or
+// an empty string if the request was not routed by a
+// wildcard entry.
+//
+// For a request to a.b.example.com, it returns "a.b".
+func HostWildcardFromContext(ctx context.Context) string {
+	sub, _ := ctx.Value(hostWildcardKey{}).(string)
+	return sub
+}
+
+// hostTrie is a trie of host labels, in reverse order,
+// that holds wildcard entries. The entry at a node
+// matches any host with at least one more label.
+type hostTrie struct {
+	h        http.Handler
+	children map[string]*hostTrie
+}
+
+// hostRoutes is the routing table of HostSwitch and
+// SafeHostSwitch.
+//
+// Entries for a specific port are held in a separate
+// routing table in ports.
+type hostRoutes struct {
+	exact     map[string]http.Handler
+	wildcards hostTrie
+
+	ports map[string]*hostRoutes
+}
+
+// parseHostPattern validates and normalizes a host, with
+// an optional leading wildcard label and an optional
+// port.
+//
+// An empty pattern is accepted as an exact entry that
+// matches requests without a Host header.
+func parseHostPattern(pattern string) (host, port string, err error) {
+	if pattern == "" {
+		return "", "", nil
+	}
+
+	host = pattern
+	switch {
+	case strings.HasPrefix(host, "["):
+		if strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		} else if host, port, err = net.SplitHostPort(host); err != nil {
+			return "", "", fmt.Errorf("handlers: invalid host %q", pattern)
+		}
+	case net.ParseIP(host) == nil:
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			host, port = host[:i], host[i+1:]
+		}
+	}
+
+	if port != "" {
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+			return "", "", fmt.Errorf("handlers: invalid port in host %q", pattern)
+		}
+	}
+
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.Contains(name, "*") ||
+		strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return "", "", fmt.Errorf("handlers: invalid host %q", pattern)
+	}
+
+	host, err = normalizeHostPattern(host)
+	if err != nil {
+		return "", "", fmt.Errorf("handlers: invalid host %q: %v", pattern, err)
+	}
+
+	return host, port, nil
+}
+
+// requestPort returns the port the request was made to.
+//
+// If listener is true, it is the port of the local
+// address the request was received on. Otherwise it is
+// the port of the Host header, or the default port of
+// the scheme.
+func requestPort(r *http.Request, listener bool) string {
+	if listener {
+		addr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		if addr == nil {
+			return ""
+		}
+
+		_, port, _ := net.SplitHostPort(addr.String())
+		return port
+	}
+
+	if port := (&url.URL{Host: r.Host}).Port(); port != "" {
+		return port
+	}
+
+	if r.TLS != nil {
+		return "443"
+	}
+
+	return "80"
+}
+
+// add adds h to the routing table and reports whether the
+// host had already been added.
+func (hr *hostRoutes) add(host, port string, h http.Handler) (dup bool) {
+	if port != "" {
+		pr := hr.ports[port]
+		if pr == nil {
+			if hr.ports == nil {
+				hr.ports = make(map[string]*hostRoutes)
+			}
+
+			pr = new(hostRoutes)
+			hr.ports[port] = pr
+		}
+
+		return pr.add(host, "", h)
+	}
+
+	if !strings.HasPrefix(host, "*.") {
+		if _, dup := hr.exact[host]; dup {
+			return true
+		}
+
+		if hr.exact == nil {
+			hr.exact = make(map[string]http.Handler)
+		}
+
+		hr.exact[host] = h
+		return false
+	}
+
+	labels := strings.Split(host[len("*."):], ".")
+
+	t := &hr.wildcards
+	for i := len(labels) - 1; i >= 0; i-- {
+		next := t.children[labels[i]]
+		if next == nil {
+			if t.children == nil {
+				t.children = make(map[string]*hostTrie)
+			}
+
+			next = new(hostTrie)
+			t.children[labels[i]] = next
+		}
+
+		t = next
+	}
+
+	if t.h != nil {
+		return true
+	}
+
+	t.h = h
+	return false
+}
+
+// remove removes host from the routing table and reports
+// whether it had been added.
+func (hr *hostRoutes) remove(host, port string) bool {
+	if port != "" {
+		pr := hr.ports[port]
+		return pr != nil && pr.remove(host, "")
+	}
+
+	if !strings.HasPrefix(host, "*.") {
+		_, ok := hr.exact[host]
+		delete(hr.exact, host)
+		return ok
+	}
+
+	labels := strings.Split(host[len("*."):], ".")
+
+	t := &hr.wildcards
+	for i := len(labels) - 1; i >= 0 && t != nil; i-- {
+		t = t.children[labels[i]]
+	}
+
+	if t == nil || t.h == nil {
+		return false
+	}
+
+	t.h = nil
+	return true
+}
+
+// each calls f for every entry in the routing table. The
+// host passed to f is in the form accepted by
+// parseHostPattern.
+func (hr *hostRoutes) each(f func(host string, h http.Handler)) {
+	hr.eachPort("", f)
+
+	for port, pr := range hr.ports {
+		pr.eachPort(port, f)
+	}
+}
+
+func (hr *hostRoutes) eachPort(port string, f func(host string, h http.Handler)) {
+	withPort := func(host string) string {
+		if port == "" {
+			return host
+		}
+
+		return net.JoinHostPort(host, port)
+	}
+
+	for host, h := range hr.exact {
+		f(withPort(host), h)
+	}
+
+	var walk func(t *hostTrie, name string)
+	walk = func(t *hostTrie, name string) {
+		if t.h != nil {
+			f(withPort("*."+name), t.h)
+		}
+
+		for label, child := range t.children {
+			if name != "" {
+				label += "." + name
+			}
+
+			walk(child, label)
+		}
+	}
+	walk(&hr.wildcards, "")
+}
+
+// lookup returns the handler for host. Entries for the
+// port take priority over entries for any port, exact
+// entries over wildcard entries, and longer wildcard
+// entries over shorter ones.
+func (hr *hostRoutes) lookup(host, port string) (h http.Handler, wildcard string, ok bool) {
+	if pr := hr.ports[port]; pr != nil && port != "" {
+		if h, wildcard, ok := pr.lookup(host, ""); ok {
+			return h, wildcard, ok
+		}
+	}
+
+	if h, ok := hr.exact[host]; ok {
+		return h, "", true
+	}
+
+	return hr.lookupWildcard(host)
+}
+
+// lookupWildcard returns the handler of the longest
+// wildcard entry that matches host.
+func (hr *hostRoutes) lookupWildcard(host string) (h http.Handler, wildcard string, ok bool) {
+	t, rest := &hr.wildcards, host
+	for rest != "" {
+		idx := strings.LastIndexByte(rest, '.')
+
+		t = t.children[rest[idx+1:]]
+		if t == nil {
+			break
+		}
+
+		if idx < 0 {
+			// The host itself, which a wildcard
+			// does not match.
+			break
+		}
+
+		rest = rest[:idx]
+
+		if t.h != nil {
+			h, wildcard, ok = t.h, rest, true
+		}
+	}
+
+	return h, wildcard, ok
+}
+
+// cloneFor returns a copy of the routing table that may
+// be passed to add or remove for host and port without
+// modifying hr. Only the parts of the routing table that
+// add or remove would modify are copied.
+func (hr *hostRoutes) cloneFor(host, port string) *hostRoutes {
+	c := *hr
+
+	if port != "" {
+		c.ports = make(map[string]*hostRoutes, len(hr.ports)+1)
+		for p, pr := range hr.ports {
+			c.ports[p] = pr
+		}
+
+		pr := hr.ports[port]
+		if pr == nil {
+			pr = new(hostRoutes)
+		}
+
+		c.ports[port] = pr.cloneFor(host, "")
+		return &c
+	}
+
+	if !strings.HasPrefix(host, "*.") {
+		c.exact = make(map[string]http.Handler, len(hr.exact)+1)
+		for host, h := range hr.exact {
+			c.exact[host] = h
+		}
+
+		return &c
+	}
+
+	labels := strings.Split(host[len("*."):], ".")
+
+	t := &c.wildcards
+	for i := len(labels) - 1; i >= 0; i-- {
+		children := make(map[string]*hostTrie, len(t.children)+1)
+		for label, child := range t.children {
+			children[label] = child
+		}
+
+		t.children = children
+
+		next := t.children[labels[i]]
+		if next == nil {
+			break
+		}
+
+		cc := *next
+		t.children[labels[i]] = &cc
+		t = &cc
+	}
+
+	return &c
+}
+
+// serve routes the request to the handler for host and
+// port or to notFound.
+func (hr *hostRoutes) serve(w http.ResponseWriter, r *http.Request, host, port string, notFound http.Handler) {
+	h, wildcard, ok := hr.lookup(host, port)
+	serveHostRoute(w, r, h, wildcard, ok, notFound)
+}
+
+// serveHostRoute calls h, with any wildcard labels in the
+// request context, if ok is true, or notFound.
+func serveHostRoute(w http.ResponseWriter, r *http.Request, h http.Handler, wildcard string, ok bool, notFound http.Handler) {
+	switch {
+	case ok && wildcard != "":
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hostWildcardKey{}, wildcard)))
+	case ok:
+		h.ServeHTTP(w, r)
+	case notFound != nil:
+		notFound.ServeHTTP(w, r)
+	default:
+		http.Error(w, forbiddenText, http.StatusForbidden)
+	}
+}
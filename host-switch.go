@@ -5,51 +5,61 @@
 
 package handlers
 
-import (
-	"net/http"
-	"net/url"
-)
+import "net/http"
 
 // HostSwitch is a http.Handler that routes
 // the request based on the Host header.
+//
+// Hosts may have a leading wildcard label, like
+// *.example.com, that matches any subdomain. Exact
+// entries take priority over wildcard entries and the
+// most specific wildcard entry is used. The labels
+// matched by the wildcard are available from
+// HostWildcardFromContext.
+//
+// Hosts are matched case-insensitively, ignoring any
+// trailing dot, and internationalized domain names match
+// in both their Unicode and punycode forms.
+//
+// Hosts may include a port, like example.com:8443, in
+// which case they only match requests to that port.
+// Entries with a port take priority over entries without
+// one. Requests without a port in the Host header are
+// treated as being to the default port of the scheme.
 type HostSwitch struct {
-	m map[string]http.Handler
+	routes hostRoutes
 
 	// NotFound is invoked for hosts
 	// that have not been added to the
 	// host switch.
 	NotFound http.Handler
+
+	// ListenerPort matches entries with a port
+	// against the port of the local address the
+	// request was received on, rather than the port
+	// of the Host header.
+	ListenerPort bool
 }
 
-// Add adds a http.Handler to the host switch.
+// Add adds a http.Handler to the host switch. An empty
+// host matches requests without a Host header.
 //
-// It panics if the host has already been added.
+// It panics if the host is invalid or has already been
+// added.
 func (hs *HostSwitch) Add(host string, h http.Handler) {
-	if hs.m == nil {
-		hs.m = make(map[string]http.Handler)
+	norm, port, err := parseHostPattern(host)
+	if err != nil {
+		panic(err)
 	}
 
-	if _, dup := hs.m[host]; dup {
+	if hs.routes.add(norm, port, h) {
 		panic("handlers: a handle is already registered for host '" + host + "'")
 	}
-
-	hs.m[host] = h
 }
 
 // ServeHTTP implements http.Handler.
 func (hs *HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	host := (&url.URL{Host: r.Host}).Hostname()
-
-	if h, ok := hs.m[host]; ok {
-		h.ServeHTTP(w, r)
-		return
-	}
-
-	if hs.NotFound != nil {
-		hs.NotFound.ServeHTTP(w, r)
-	} else {
-		http.Error(w, forbiddenText, http.StatusForbidden)
-	}
+	hs.routes.serve(w, r, requestHost(r), requestPort(r, hs.ListenerPort), hs.NotFound)
 }
 
 var forbiddenText = http.StatusText(http.StatusForbidden)
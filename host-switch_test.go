@@ -6,11 +6,14 @@
 package handlers
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHostSwitchAdd(t *testing.T) {
@@ -26,6 +29,29 @@ func TestHostSwitchAdd(t *testing.T) {
 	})
 }
 
+func TestHostSwitchEmptyHost(t *testing.T) {
+	var hs HostSwitch
+
+	assert.NotPanics(t, func() {
+		hs.Add("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(999)
+		}))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = ""
+
+	w := httptest.NewRecorder()
+	hs.ServeHTTP(w, r)
+
+	assert.Equal(t, 999, w.Code)
+
+	w = httptest.NewRecorder()
+	hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
 func TestHostSwitchNotFound(t *testing.T) {
 	hs := &HostSwitch{
 		NotFound: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -74,3 +100,184 @@ func TestHostSwitch(t *testing.T) {
 
 	assert.Equal(t, 997, w.Code, "HostSwitch invoked incorrect http.Handler")
 }
+
+func TestHostSwitchWildcard(t *testing.T) {
+	var hs HostSwitch
+
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+			w.Header().Set("X-Wildcard", HostWildcardFromContext(r.Context()))
+		})
+	}
+
+	hs.Add("example.com", handler("exact"))
+	hs.Add("*.example.com", handler("wildcard"))
+	hs.Add("*.customers.example.com", handler("customers"))
+	hs.Add("vip.customers.example.com", handler("vip"))
+
+	for _, tc := range []struct {
+		host, handler, wildcard string
+	}{
+		{"example.com", "exact", ""},
+		{"www.example.com", "wildcard", "www"},
+		{"a.b.example.com", "wildcard", "a.b"},
+		{"customers.example.com", "wildcard", "customers"},
+		{"acme.customers.example.com", "customers", "acme"},
+		{"x.acme.customers.example.com", "customers", "x.acme"},
+		{"vip.customers.example.com", "vip", ""},
+		{"example.org", "", ""},
+		{"com", "", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/", nil)
+
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.handler, w.Header().Get("X-Handler"), tc.host)
+		assert.Equal(t, tc.wildcard, w.Header().Get("X-Wildcard"), tc.host)
+	}
+
+	assert.Panics(t, func() {
+		hs.Add("*.example.com", handler("dup"))
+	})
+
+	for _, host := range []string{"*", "*.", "a.*.example.com", "**.example.com", ".example.com", "a..b"} {
+		assert.Panics(t, func() {
+			hs.Add(host, handler("invalid"))
+		}, host)
+	}
+}
+
+func TestHostSwitchNormalize(t *testing.T) {
+	var hs HostSwitch
+
+	hs.Add("Example.COM.", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(997)
+	}))
+	hs.Add("bücher.example", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(998)
+	}))
+	hs.Add("*.XN--BCHER-KVA.example", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	}))
+	hs.Add("foo_bar.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(996)
+	}))
+
+	for _, tc := range []struct {
+		host string
+		code int
+	}{
+		{"example.com", 997},
+		{"EXAMPLE.com.", 997},
+		{"example.com.:8080", 997},
+		{"bücher.example", 998},
+		{"BÜCHER.example", 998},
+		{"xn--bcher-kva.example", 998},
+		{"www.bücher.example", 999},
+		{"foo_bar.example.com", 996},
+		{"FOO_BAR.example.com:8080", 996},
+		{"foo-bar.example.com", http.StatusForbidden},
+		{"exa mple.com", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, tc.host)
+	}
+
+	assert.Panics(t, func() {
+		hs.Add("EXAMPLE.com", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	})
+
+	for _, host := range []string{"exa mple.com", "-example.com", "a/b.example.com", "a@b.example.com"} {
+		assert.Panics(t, func() {
+			hs.Add(host, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		}, host)
+	}
+}
+
+func TestHostSwitchPort(t *testing.T) {
+	var hs HostSwitch
+
+	handler := func(code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		})
+	}
+
+	hs.Add("example.com", handler(990))
+	hs.Add("example.com:8443", handler(991))
+	hs.Add("example.com:443", handler(992))
+	hs.Add("*.example.com:8443", handler(993))
+	hs.Add("[2001:db8::1]:8080", handler(994))
+	hs.Add("2001:db8::1", handler(995))
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"http://example.com/", 990},
+		{"http://example.com:8080/", 990},
+		{"http://example.com:8443/", 991},
+		{"https://example.com/", 992},
+		{"http://example.com:443/", 992},
+		{"http://www.example.com:8443/", 993},
+		{"http://www.example.com/", http.StatusForbidden},
+		{"http://[2001:db8::1]:8080/", 994},
+		{"http://[2001:db8::1]/", 995},
+	} {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.url)
+	}
+
+	assert.Panics(t, func() {
+		hs.Add("example.com:8443", handler(999))
+	})
+
+	for _, host := range []string{"example.com:", "example.com:0", "example.com:65536", "example.com:https", "[2001:db8::1"} {
+		assert.Panics(t, func() {
+			hs.Add(host, handler(999))
+		}, host)
+	}
+}
+
+func TestHostSwitchListenerPort(t *testing.T) {
+	hs := &HostSwitch{ListenerPort: true}
+
+	hs.Add("example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(997)
+	}))
+	hs.Add("example.com:8443", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(998)
+	}))
+
+	for _, tc := range []struct {
+		local string
+		code  int
+	}{
+		{"192.0.2.1:8443", 998},
+		{"192.0.2.1:443", 997},
+		{"", 997},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com:443/", nil)
+
+		if tc.local != "" {
+			addr, err := net.ResolveTCPAddr("tcp", tc.local)
+			require.NoError(t, err)
+
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, addr))
+		}
+
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, tc.local)
+	}
+}
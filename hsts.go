@@ -0,0 +1,230 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HSTSPreloadMinMaxAge is the minimum max-age accepted by
+// https://hstspreload.org/ for preloading.
+const HSTSPreloadMinMaxAge = 365 * 24 * time.Hour
+
+// HSTS is a typed Strict-Transport-Security policy.
+//
+// Unlike SecurityHeaders.StrictTransportSecurity, the
+// header is only sent on secure requests, as browsers
+// ignore it on plain-HTTP responses.
+type HSTS struct {
+	// How long browsers should cache the policy. It is
+	// rounded down to whole seconds.
+	MaxAge time.Duration
+
+	// Applies the policy to all subdomains.
+	IncludeSubDomains bool
+
+	// Signals consent to have the site preloaded
+	// into browsers. See ValidatePreload.
+	Preload bool
+
+	// Optionally specifies later stages of the
+	// policy, in ascending order of After, so that
+	// max-age can be ramped up gradually. The policy
+	// above is used until the first stage's After
+	// time.
+	Stages []HSTSStage
+
+	// Optionally reports whether the request is
+	// secure. If nil, only requests received over
+	// TLS are secure.
+	//
+	// TrustForwardedProto may be used for requests
+	// received from a TLS terminating proxy.
+	IsSecure func(*http.Request) bool
+}
+
+// HSTSStage is a stage of a HSTS policy that takes effect
+// at a given time.
+type HSTSStage struct {
+	// The time this stage takes effect.
+	After time.Time
+
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+// current returns the stage in effect at now.
+func (h *HSTS) current(now time.Time) HSTSStage {
+	stage := HSTSStage{
+		MaxAge:            h.MaxAge,
+		IncludeSubDomains: h.IncludeSubDomains,
+		Preload:           h.Preload,
+	}
+
+	for _, s := range h.Stages {
+		if now.Before(s.After) {
+			break
+		}
+
+		stage = s
+	}
+
+	return stage
+}
+
+// String returns the serialized policy, at the current
+// time, suitable for use in a Strict-Transport-Security
+// header.
+func (h *HSTS) String() string {
+	return h.current(time.Now()).String()
+}
+
+// String returns the serialized stage suitable for use in
+// a Strict-Transport-Security header.
+func (s HSTSStage) String() string {
+	v := "max-age=" + strconv.FormatInt(int64(s.MaxAge/time.Second), 10)
+
+	if s.IncludeSubDomains {
+		v += "; includeSubDomains"
+	}
+
+	if s.Preload {
+		v += "; preload"
+	}
+
+	return v
+}
+
+func (s HSTSStage) validatePreload() error {
+	if s.MaxAge < HSTSPreloadMinMaxAge {
+		return fmt.Errorf("handlers: HSTS max-age must be at least %d seconds for preloading",
+			int64(HSTSPreloadMinMaxAge/time.Second))
+	}
+
+	if !s.IncludeSubDomains {
+		return fmt.Errorf("handlers: HSTS includeSubDomains is required for preloading")
+	}
+
+	if !s.Preload {
+		return fmt.Errorf("handlers: HSTS preload directive is required for preloading")
+	}
+
+	return nil
+}
+
+// Validate returns an error if any max-age is negative,
+// if the stages are out of order or if any stage that sets
+// preload does not meet the hstspreload.org submission
+// requirements.
+func (h *HSTS) Validate() error {
+	stages := append([]HSTSStage{{
+		MaxAge:            h.MaxAge,
+		IncludeSubDomains: h.IncludeSubDomains,
+		Preload:           h.Preload,
+	}}, h.Stages...)
+
+	for i, s := range stages {
+		if s.MaxAge < 0 {
+			return fmt.Errorf("handlers: HSTS max-age must not be negative")
+		}
+
+		if i > 1 && s.After.Before(stages[i-1].After) {
+			return fmt.Errorf("handlers: HSTS stages must be in ascending order")
+		}
+
+		if s.Preload {
+			if err := s.validatePreload(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidatePreload returns an error if the final stage of
+// the policy does not meet the hstspreload.org submission
+// requirements: a max-age of at least one year, and both
+// the includeSubDomains and preload directives.
+func (h *HSTS) ValidatePreload() error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+
+	final := HSTSStage{
+		MaxAge:            h.MaxAge,
+		IncludeSubDomains: h.IncludeSubDomains,
+		Preload:           h.Preload,
+	}
+	if len(h.Stages) != 0 {
+		final = h.Stages[len(h.Stages)-1]
+	}
+
+	return final.validatePreload()
+}
+
+func (h *HSTS) secure(r *http.Request) bool {
+	if h.IsSecure != nil {
+		return h.IsSecure(r)
+	}
+
+	return r.TLS != nil
+}
+
+// TrustForwardedProto returns a function, for use as
+// HSTS.IsSecure, that reports whether the request was
+// received over TLS, or was received from one of the
+// given trusted proxies with an X-Forwarded-Proto header
+// of https.
+//
+// Each proxy is either an IP address or a CIDR, like
+// 10.0.0.0/8. It panics if a proxy cannot be parsed.
+func TrustForwardedProto(proxies ...string) func(*http.Request) bool {
+	nets := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return func(r *http.Request) bool {
+		if r.TLS != nil {
+			return true
+		}
+
+		if !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			return false
+		}
+
+		ip := net.ParseIP((&url.URL{Host: r.RemoteAddr}).Hostname())
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHSTSString(t *testing.T) {
+	assert.Equal(t, "max-age=300", (&HSTS{MaxAge: 5 * time.Minute}).String())
+	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", (&HSTS{
+		MaxAge:            HSTSPreloadMinMaxAge,
+		IncludeSubDomains: true,
+		Preload:           true,
+	}).String())
+}
+
+func TestHSTSStages(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := &HSTS{
+		MaxAge: 5 * time.Minute,
+		Stages: []HSTSStage{
+			{After: start, MaxAge: 7 * 24 * time.Hour, IncludeSubDomains: true},
+			{After: start.AddDate(0, 1, 0), MaxAge: 30 * 24 * time.Hour, IncludeSubDomains: true},
+			{After: start.AddDate(0, 2, 0), MaxAge: 2 * HSTSPreloadMinMaxAge, IncludeSubDomains: true, Preload: true},
+		},
+	}
+
+	assert.NoError(t, h.Validate())
+	assert.NoError(t, h.ValidatePreload())
+
+	assert.Equal(t, "max-age=300", h.current(start.Add(-time.Second)).String())
+	assert.Equal(t, "max-age=604800; includeSubDomains", h.current(start).String())
+	assert.Equal(t, "max-age=2592000; includeSubDomains", h.current(start.AddDate(0, 1, 15)).String())
+	assert.Equal(t, "max-age=63072000; includeSubDomains; preload", h.current(start.AddDate(1, 0, 0)).String())
+	assert.Equal(t, "max-age=63072000; includeSubDomains; preload", h.String())
+
+	h.Stages[0], h.Stages[1] = h.Stages[1], h.Stages[0]
+	assert.Error(t, h.Validate())
+}
+
+func TestHSTSValidate(t *testing.T) {
+	for _, h := range []*HSTS{
+		{MaxAge: -time.Second},
+		{MaxAge: HSTSPreloadMinMaxAge, Preload: true},
+		{MaxAge: time.Hour, IncludeSubDomains: true, Preload: true},
+		{Stages: []HSTSStage{{MaxAge: time.Hour, Preload: true}}},
+	} {
+		assert.Error(t, h.Validate(), "%s", h)
+	}
+
+	for _, h := range []*HSTS{
+		{MaxAge: HSTSPreloadMinMaxAge},
+		{MaxAge: HSTSPreloadMinMaxAge, IncludeSubDomains: true},
+		{MaxAge: time.Hour, Stages: []HSTSStage{{MaxAge: HSTSPreloadMinMaxAge, IncludeSubDomains: true}}},
+	} {
+		assert.NoError(t, h.Validate(), "%s", h)
+		assert.Error(t, h.ValidatePreload(), "%s", h)
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	sh := &SecurityHeaders{
+		Handler: h,
+
+		StrictTransportSecurity: "fail",
+		HSTS:                    &HSTS{MaxAge: HSTSPreloadMinMaxAge},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	assert.NotContains(t, w.Header(), "Strict-Transport-Security")
+
+	sh.HSTS.IsSecure = TrustForwardedProto("192.0.2.0/24", "2001:db8::1")
+
+	for _, tc := range []struct {
+		remote, proto string
+		secure        bool
+	}{
+		{"192.0.2.1:1234", "https", true},
+		{"192.0.2.1:1234", "HTTPS", true},
+		{"192.0.2.1:1234", "http", false},
+		{"192.0.2.1:1234", "", false},
+		{"198.51.100.1:1234", "https", false},
+		{"[2001:db8::1]:1234", "https", true},
+		{"[2001:db8::2]:1234", "https", false},
+	} {
+		r = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set("X-Forwarded-Proto", tc.proto)
+
+		w = httptest.NewRecorder()
+		sh.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.secure, w.Header().Get("Strict-Transport-Security") != "", "%s %s", tc.remote, tc.proto)
+	}
+
+	assert.Panics(t, func() {
+		TrustForwardedProto("not-an-ip")
+	})
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			HSTS: &HSTS{MaxAge: time.Hour, Preload: true},
+		})
+	})
+}
+
+func TestSecurityHeadersHSTSStages(t *testing.T) {
+	h := SecurityHeadersWrap(&SecurityHeaders{
+		HSTS: &HSTS{
+			MaxAge: time.Hour,
+			Stages: []HSTSStage{
+				{After: time.Now().Add(50 * time.Millisecond), MaxAge: 2 * time.Hour},
+			},
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+		return w.Header().Get("Strict-Transport-Security")
+	}
+
+	assert.Equal(t, "max-age=3600", serve())
+
+	time.Sleep(60 * time.Millisecond)
+
+	assert.Equal(t, "max-age=7200", serve(), "stage did not take effect after wrap")
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrorCatalog is a catalog of localized error messages
+// and error pages. The language is negotiated from the
+// Accept-Language request header.
+//
+// Language tags are matched case-insensitively. When
+// no exact match is found for a requested tag, its
+// subtags are removed one at a time from the end, so
+// that a request for de-CH will fall back to de.
+type ErrorCatalog struct {
+	// Messages is a map of language tag to a map
+	// of HTTP status code to localized status
+	// text.
+	Messages map[string]map[int]string
+
+	// Pages is a map of language tag to a map of
+	// HTTP status code to a http.Handler that
+	// serves a localized error page, such as
+	// those returned by (*ErrorPages).Handlers.
+	//
+	// Pages take priority over Messages for the
+	// same language.
+	Pages map[string]map[int]http.Handler
+
+	// The language to use when none of the
+	// languages in Accept-Language are in the
+	// catalog, defaults to en.
+	//
+	// If the default language has no entry for a
+	// status code, http.StatusText is used, with a
+	// Content-Language of en.
+	DefaultLanguage string
+}
+
+// ErrorCode returns a http.Handler that responds with the
+// given HTTP status code and the localized error page or
+// status text best matching the request's
+// Accept-Language header.
+//
+// It sets the Content-Language header to the selected
+// language and adds Accept-Language to the Vary header.
+func (c *ErrorCatalog) ErrorCode(code int) Handler {
+	le := &localizedError{
+		code:  code,
+		langs: make(map[string]localizedEntry),
+	}
+
+	for lang, msgs := range c.Messages {
+		if msg, ok := msgs[code]; ok {
+			le.langs[strings.ToLower(lang)] = localizedEntry{lang: lang, msg: msg}
+		}
+	}
+
+	for lang, pages := range c.Pages {
+		if h, ok := pages[code]; ok {
+			le.langs[strings.ToLower(lang)] = localizedEntry{lang: lang, h: h}
+		}
+	}
+
+	le.def.lang = c.DefaultLanguage
+	if le.def.lang == "" {
+		le.def.lang = "en"
+	}
+
+	if e, ok := le.langs[strings.ToLower(le.def.lang)]; ok {
+		le.def = e
+	} else {
+		// http.StatusText is in English, whatever
+		// the default language.
+		le.def = localizedEntry{lang: "en", msg: http.StatusText(code)}
+	}
+
+	return le
+}
+
+type localizedEntry struct {
+	lang string
+	msg  string
+	h    http.Handler
+}
+
+type localizedError struct {
+	code  int
+	langs map[string]localizedEntry
+	def   localizedEntry
+}
+
+func (le *localizedError) lookup(accept string) localizedEntry {
+	for _, tag := range parseAcceptLanguage(accept) {
+		if tag == "*" {
+			return le.def
+		}
+
+		for {
+			if e, ok := le.langs[tag]; ok {
+				return e
+			}
+
+			idx := strings.LastIndexByte(tag, '-')
+			if idx < 0 {
+				break
+			}
+
+			tag = tag[:idx]
+		}
+	}
+
+	return le.def
+}
+
+func (le *localizedError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e := le.lookup(r.Header.Get("Accept-Language"))
+
+	h := w.Header()
+	h.Set("Content-Language", e.lang)
+	h.Add("Vary", "Accept-Language")
+
+	if e.h != nil {
+		e.h.ServeHTTP(w, r)
+	} else {
+		http.Error(w, e.msg, le.code)
+	}
+}
+
+// parseAcceptLanguage returns the lowercased language
+// tags from an Accept-Language header in order of
+// preference. Tags with a quality value of zero are
+// omitted.
+func parseAcceptLanguage(accept string) []string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+
+	var langs []langQ
+
+	for _, part := range strings.Split(accept, ",") {
+		tag, params := part, ""
+		if idx := strings.IndexByte(part, ';'); idx >= 0 {
+			tag, params = part[:idx], part[idx+1:]
+		}
+
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil || v < 0 || v > 1 {
+				v = 0
+			}
+
+			q = v
+		}
+
+		if q > 0 {
+			langs = append(langs, langQ{tag, q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+
+	return tags
+}
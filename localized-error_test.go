@@ -0,0 +1,103 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		tags   []string
+	}{
+		{"", []string{}},
+		{"de", []string{"de"}},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", []string{"fr-ch", "fr", "en", "de", "*"}},
+		{"en;q=0.5, de", []string{"de", "en"}},
+		{"en;q=0, de;q=0.1", []string{"de"}},
+		{"en;q=bad, de", []string{"de"}},
+		{"en, , DE-at", []string{"en", "de-at"}},
+	} {
+		assert.Equal(t, tc.tags, parseAcceptLanguage(tc.accept), "%q", tc.accept)
+	}
+}
+
+func TestErrorCatalog(t *testing.T) {
+	c := &ErrorCatalog{
+		Messages: map[string]map[int]string{
+			"de":    {http.StatusNotFound: "Nicht gefunden"},
+			"fr":    {http.StatusNotFound: "Introuvable"},
+			"pt-BR": {http.StatusNotFound: "Não encontrado"},
+		},
+	}
+	h := c.ErrorCode(http.StatusNotFound)
+
+	for _, tc := range []struct {
+		accept, lang, msg string
+	}{
+		{"", "en", http.StatusText(http.StatusNotFound)},
+		{"de", "de", "Nicht gefunden"},
+		{"de-CH", "de", "Nicht gefunden"},
+		{"pt-br", "pt-BR", "Não encontrado"},
+		{"pt", "en", http.StatusText(http.StatusNotFound)},
+		{"es, fr;q=0.8, de;q=0.9", "de", "Nicht gefunden"},
+		{"es, *;q=0.5, fr;q=0.1", "en", http.StatusText(http.StatusNotFound)},
+		{"de;q=0, fr;q=0.1", "fr", "Introuvable"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", tc.accept)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, tc.lang, w.Header().Get("Content-Language"), "%q", tc.accept)
+		assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+		assert.Equal(t, tc.msg+"\n", w.Body.String(), "%q", tc.accept)
+	}
+}
+
+func TestErrorCatalogPages(t *testing.T) {
+	c := &ErrorCatalog{
+		Messages: map[string]map[int]string{
+			"de": {http.StatusNotFound: "Nicht gefunden"},
+		},
+		Pages: map[string]map[int]http.Handler{
+			"de": {http.StatusNotFound: ServeError(http.StatusNotFound, []byte("<p>Nicht gefunden</p>"), "text/html")},
+			"fr": {http.StatusBadGateway: ErrorMessage("Mauvaise passerelle", http.StatusBadGateway)},
+		},
+		DefaultLanguage: "de",
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "fr")
+
+	w := httptest.NewRecorder()
+	c.ErrorCode(http.StatusNotFound).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "de", w.Header().Get("Content-Language"))
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<p>Nicht gefunden</p>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	c.ErrorCode(http.StatusBadGateway).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "fr", w.Header().Get("Content-Language"))
+	assert.Equal(t, "Mauvaise passerelle\n", w.Body.String())
+	w = httptest.NewRecorder()
+	c.ErrorCode(http.StatusInternalServerError).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+	assert.Equal(t, http.StatusText(http.StatusInternalServerError)+"\n", w.Body.String())
+}
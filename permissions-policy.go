@@ -0,0 +1,177 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The special allowlist entries of a PermissionsPolicy.
+// Any other entry is treated as an origin, such as
+// https://example.com.
+const (
+	PermissionsSelf = "self"
+	PermissionsAll  = "*"
+	PermissionsSrc  = "src"
+)
+
+// PermissionsPolicy is a typed Permissions-Policy. It is
+// a map of feature name, for example geolocation, to an
+// allowlist.
+//
+// An empty allowlist disables the feature entirely.
+//
+// See https://www.w3.org/TR/permissions-policy/ for more
+// information.
+type PermissionsPolicy map[string][]string
+
+var (
+	permissionsFeatureRe = regexp.MustCompile(`^[a-z*][a-z0-9_.*-]*$`)
+	originRe             = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://(?:[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*|\[[0-9a-fA-F:.]+\])(?::[0-9]+)?$`)
+	sfStringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// String returns the policy serialized as an RFC 8941
+// structured field dictionary, suitable for use in a
+// Permissions-Policy header.
+//
+// Features are always serialized in sorted order.
+func (pp PermissionsPolicy) String() string {
+	features := make([]string, 0, len(pp))
+	for feature := range pp {
+		features = append(features, feature)
+	}
+
+	sort.Strings(features)
+
+	var buf bytes.Buffer
+
+	for i, feature := range features {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+
+		buf.WriteString(feature)
+		buf.WriteByte('=')
+
+		allow := pp[feature]
+		if len(allow) == 1 && allow[0] == PermissionsAll {
+			buf.WriteString(PermissionsAll)
+			continue
+		}
+
+		buf.WriteByte('(')
+
+		for j, origin := range allow {
+			if j > 0 {
+				buf.WriteByte(' ')
+			}
+
+			switch origin {
+			case PermissionsSelf, PermissionsAll, PermissionsSrc:
+				buf.WriteString(origin)
+			default:
+				buf.WriteByte('"')
+				buf.WriteString(sfStringEscaper.Replace(origin))
+				buf.WriteByte('"')
+			}
+		}
+
+		buf.WriteByte(')')
+	}
+
+	return buf.String()
+}
+
+// Validate returns an error if the policy contains an
+// invalid feature name or an allowlist entry that is
+// not a valid origin.
+func (pp PermissionsPolicy) Validate() error {
+	for feature, allow := range pp {
+		if !permissionsFeatureRe.MatchString(feature) {
+			return fmt.Errorf("handlers: invalid permissions policy feature %q", feature)
+		}
+
+		for _, origin := range allow {
+			switch origin {
+			case PermissionsSelf, PermissionsSrc:
+				continue
+			case PermissionsAll:
+				if len(allow) != 1 {
+					return fmt.Errorf("handlers: * must be the only entry in the %s allowlist", feature)
+				}
+
+				continue
+			}
+
+			if !validOrigin(origin) {
+				return fmt.Errorf("handlers: invalid origin %q in %s allowlist", origin, feature)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validOrigin(origin string) bool {
+	return originRe.MatchString(origin)
+}
+
+// ParseFeaturePolicy translates a Feature-Policy header
+// value, for example
+//  geolocation 'self' https://example.com; camera 'none'
+// into the equivalent PermissionsPolicy.
+func ParseFeaturePolicy(policy string) (PermissionsPolicy, error) {
+	pp := make(PermissionsPolicy)
+
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+
+		feature := strings.ToLower(fields[0])
+		if _, dup := pp[feature]; dup {
+			return nil, fmt.Errorf("handlers: duplicate feature policy feature %q", feature)
+		}
+
+		allow := make([]string, 0, len(fields)-1)
+
+		for _, v := range fields[1:] {
+			switch strings.ToLower(v) {
+			case "'none'":
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("handlers: 'none' must be the only entry for feature %q", feature)
+				}
+			case "'self'":
+				allow = append(allow, PermissionsSelf)
+			case "'src'":
+				allow = append(allow, PermissionsSrc)
+			case "*":
+				allow = append(allow, PermissionsAll)
+			default:
+				origin := strings.TrimSuffix(v, "/")
+				if !validOrigin(origin) {
+					return nil, fmt.Errorf("handlers: invalid origin %q for feature %q", v, feature)
+				}
+
+				allow = append(allow, origin)
+			}
+		}
+
+		pp[feature] = allow
+	}
+
+	if err := pp.Validate(); err != nil {
+		return nil, err
+	}
+
+	return pp, nil
+}
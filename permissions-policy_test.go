@@ -0,0 +1,125 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsPolicyString(t *testing.T) {
+	pp := PermissionsPolicy{
+		"geolocation": {PermissionsSelf, "https://example.com"},
+		"camera":      {},
+		"fullscreen":  {PermissionsAll},
+		"payment":     {PermissionsSelf},
+	}
+
+	assert.Equal(t, `camera=(), fullscreen=*, geolocation=(self "https://example.com"), payment=(self)`, pp.String())
+	assert.NoError(t, pp.Validate())
+	assert.Equal(t, "", PermissionsPolicy(nil).String())
+}
+
+func TestPermissionsPolicyValidate(t *testing.T) {
+	for _, pp := range []PermissionsPolicy{
+		{"Geolocation": {}},
+		{"geo location": {}},
+		{"camera": {PermissionsAll, PermissionsSelf}},
+		{"camera": {"'self'"}},
+		{"camera": {"example.com"}},
+		{"camera": {"https://example.com/path"}},
+		{"camera": {`https://exa"mple.com`}},
+	} {
+		assert.Error(t, pp.Validate(), "%v", pp)
+	}
+}
+
+func TestParseFeaturePolicy(t *testing.T) {
+	pp, err := ParseFeaturePolicy("geolocation 'self' https://example.com/; camera 'none'; fullscreen *; microphone")
+	require.NoError(t, err)
+
+	assert.Equal(t, PermissionsPolicy{
+		"geolocation": {PermissionsSelf, "https://example.com"},
+		"camera":      {},
+		"fullscreen":  {PermissionsAll},
+		"microphone":  {},
+	}, pp)
+	assert.Equal(t, `camera=(), fullscreen=*, geolocation=(self "https://example.com"), microphone=()`, pp.String())
+
+	for _, policy := range []string{
+		"camera 'none' 'self'",
+		"camera 'self'; camera 'none'",
+		"camera self",
+		"camera * 'self'",
+	} {
+		_, err := ParseFeaturePolicy(policy)
+		assert.Error(t, err, "%q", policy)
+	}
+}
+
+func TestSecurityHeadersPermissionsPolicy(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	SecurityHeadersWrap(&SecurityHeaders{
+		FeaturePolicy:          "camera 'none'",
+		TranslateFeaturePolicy: true,
+	})(h).ServeHTTP(w, r)
+
+	assert.Equal(t, "camera 'none'", w.Header().Get("Feature-Policy"))
+	assert.Equal(t, "camera=()", w.Header().Get("Permissions-Policy"))
+
+	w = httptest.NewRecorder()
+	SecurityHeadersWrap(&SecurityHeaders{
+		FeaturePolicy:          "camera 'none'",
+		TranslateFeaturePolicy: true,
+		PermissionsPolicy:      PermissionsPolicy{"camera": {PermissionsSelf}},
+	})(h).ServeHTTP(w, r)
+
+	assert.Equal(t, "camera=(self)", w.Header().Get("Permissions-Policy"))
+
+	w = httptest.NewRecorder()
+	SecurityHeadersWrap(&SecurityHeaders{
+		FeaturePolicy: "camera 'none'",
+	})(h).ServeHTTP(w, r)
+
+	assert.Empty(t, w.Header().Get("Permissions-Policy"))
+
+	c := &SecurityHeaders{
+		FeaturePolicy:          "camera 'none'",
+		TranslateFeaturePolicy: true,
+		CSP:                    &CSP{DefaultSrc: []CSPSource{CSPNone}},
+	}
+	sh := SecurityHeadersWrap(c)(h).(*SecurityHeaders)
+
+	require.NotNil(t, sh.values, "header values not precomputed")
+	assert.Equal(t, "camera=()", sh.values.permissionsPolicy)
+	assert.Equal(t, "default-src 'none'", sh.values.csp)
+
+	c.CSP.DefaultSrc = []CSPSource{CSPSelf}
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, r)
+
+	assert.Equal(t, "default-src 'none'", w.Header().Get("Content-Security-Policy"))
+
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			FeaturePolicy:          "camera self",
+			TranslateFeaturePolicy: true,
+		})(h)
+	})
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			PermissionsPolicy: PermissionsPolicy{"camera": {"self "}},
+		})(h)
+	})
+}
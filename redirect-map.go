@@ -0,0 +1,349 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedirectRule is a rule for RedirectMap.
+type RedirectRule struct {
+	// The request path to match, as determined by
+	// Type.
+	From string `json:"from"`
+
+	// The URL to redirect to.
+	To string `json:"to"`
+
+	// How From is matched, one of:
+	//  - exact: the path must equal From,
+	//  - prefix: the path must begin with From at a
+	//    path segment boundary, the remainder of the
+	//    escaped path is appended to To, or
+	//  - regex: the path must match the regular
+	//    expression From, which is anchored at both
+	//    ends, and $1 or ${name} in To are replaced
+	//    with the submatches.
+	// It defaults to exact.
+	Type string `json:"type,omitempty"`
+
+	// The HTTP status code to use when redirecting,
+	// defaults to 301 Moved Permanently.
+	Code int `json:"code,omitempty"`
+
+	// How the query string of the request is
+	// handled, one of:
+	//  - preserve: it is appended to To, or
+	//  - drop: it is discarded.
+	// It defaults to preserve.
+	Query string `json:"query,omitempty"`
+}
+
+// ParseRedirectRulesJSON reads a JSON array of
+// RedirectRules from r.
+func ParseRedirectRulesJSON(r io.Reader) ([]RedirectRule, error) {
+	var rules []RedirectRule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("handlers: invalid redirect rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+// ParseRedirectRulesCSV reads RedirectRules from r in
+// CSV format. Each record has the fields from, to, type,
+// code and query, in that order, of which all but from
+// and to are optional. Lines beginning with # are
+// ignored, as is a header record beginning with from.
+func ParseRedirectRulesCSV(r io.Reader) ([]RedirectRule, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rules []RedirectRule
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("handlers: invalid redirect rules: %v", err)
+		}
+
+		if n == 1 && strings.EqualFold(record[0], "from") {
+			continue
+		}
+
+		if len(record) < 2 || len(record) > 5 {
+			return nil, fmt.Errorf("handlers: invalid redirect rule in record %d: expected 2 to 5 fields", n)
+		}
+
+		rule := RedirectRule{From: record[0], To: record[1]}
+
+		if len(record) > 2 {
+			rule.Type = record[2]
+		}
+
+		if len(record) > 3 && record[3] != "" {
+			if rule.Code, err = strconv.Atoi(record[3]); err != nil {
+				return nil, fmt.Errorf("handlers: invalid redirect code in record %d: %v", n, err)
+			}
+		}
+
+		if len(record) > 4 {
+			rule.Query = record[4]
+		}
+
+		rules = append(rules, rule)
+	}
+}
+
+type redirectTarget struct {
+	to        string
+	code      int
+	dropQuery bool
+}
+
+type prefixRedirect struct {
+	prefix string
+	redirectTarget
+}
+
+type regexRedirect struct {
+	re *regexp.Regexp
+	redirectTarget
+}
+
+// RedirectMap returns a http.Handler that redirects
+// requests according to rules and calls h for requests
+// that do not match any rule. If h is nil, a 404 Not
+// Found error will be returned instead.
+//
+// Exact rules are matched first, with a single map
+// lookup, then prefix rules, with the longest prefix
+// taking priority, and then regex rules, in order.
+//
+// If a prefix or regex rule would redirect to a URL with
+// a different scheme, host or userinfo than its To, as
+// with a path of /old//evil.com and a prefix rule from
+// /old/ to /, a 400 Bad Request error is returned
+// instead. Submatches of regex rules may therefore only
+// be used in the path, query and fragment of To.
+//
+// It returns an error if any rule is invalid or if two
+// exact or prefix rules have the same From.
+func RedirectMap(h http.Handler, rules []RedirectRule) (Handler, error) {
+	if h == nil {
+		h = http.NotFoundHandler()
+	}
+
+	rm := &redirectMap{
+		h:     h,
+		exact: make(map[string]redirectTarget),
+	}
+
+	prefixes := make(map[string]bool)
+
+	for i, rule := range rules {
+		target, err := rule.target()
+		if err != nil {
+			return nil, fmt.Errorf("handlers: invalid redirect rule %d (%q): %v", i+1, rule.From, err)
+		}
+
+		switch rule.Type {
+		case "", "exact":
+			if _, dup := rm.exact[rule.From]; dup {
+				return nil, fmt.Errorf("handlers: duplicate redirect rule for %q", rule.From)
+			}
+
+			rm.exact[rule.From] = target
+		case "prefix":
+			if prefixes[rule.From] {
+				return nil, fmt.Errorf("handlers: duplicate redirect rule for %q", rule.From)
+			}
+
+			prefixes[rule.From] = true
+			rm.prefixes = append(rm.prefixes, prefixRedirect{rule.From, target})
+		case "regex":
+			re, err := regexp.Compile("^(?:" + rule.From + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("handlers: invalid redirect rule %d (%q): %v", i+1, rule.From, err)
+			}
+
+			rm.regexps = append(rm.regexps, regexRedirect{re, target})
+		}
+	}
+
+	sort.SliceStable(rm.prefixes, func(i, j int) bool {
+		return len(rm.prefixes[i].prefix) > len(rm.prefixes[j].prefix)
+	})
+
+	return rm, nil
+}
+
+func (rule *RedirectRule) target() (redirectTarget, error) {
+	switch rule.Type {
+	case "", "exact", "prefix":
+		if !strings.HasPrefix(rule.From, "/") {
+			return redirectTarget{}, fmt.Errorf("from must begin with /")
+		}
+	case "regex":
+	default:
+		return redirectTarget{}, fmt.Errorf("unknown type %q", rule.Type)
+	}
+
+	if rule.To == "" {
+		return redirectTarget{}, fmt.Errorf("to is required")
+	}
+
+	if _, err := url.Parse(rule.To); err != nil {
+		return redirectTarget{}, err
+	}
+
+	code := rule.Code
+	switch {
+	case code == 0:
+		code = http.StatusMovedPermanently
+	case code < 300 || code > 399:
+		return redirectTarget{}, fmt.Errorf("invalid redirect code %d", code)
+	}
+
+	var dropQuery bool
+	switch rule.Query {
+	case "", "preserve":
+	case "drop":
+		dropQuery = true
+	default:
+		return redirectTarget{}, fmt.Errorf("unknown query option %q", rule.Query)
+	}
+
+	return redirectTarget{rule.To, code, dropQuery}, nil
+}
+
+type redirectMap struct {
+	h        http.Handler
+	exact    map[string]redirectTarget
+	prefixes []prefixRedirect
+	regexps  []regexRedirect
+}
+
+func (rm *redirectMap) match(r *http.Request) (to string, target redirectTarget, ok bool) {
+	path := r.URL.Path
+
+	if target, ok := rm.exact[path]; ok {
+		return target.to, target, true
+	}
+
+	for _, p := range rm.prefixes {
+		if !hasPathPrefix(path, p.prefix) {
+			continue
+		}
+
+		// The remainder is taken from the escaped path so
+		// that escaped characters, like %3F, are not
+		// decoded into the redirect URL.
+		rest := (&url.URL{Path: path[len(p.prefix):]}).EscapedPath()
+		if escaped, prefix := r.URL.EscapedPath(), (&url.URL{Path: p.prefix}).EscapedPath(); strings.HasPrefix(escaped, prefix) {
+			rest = escaped[len(prefix):]
+		}
+
+		return p.to + rest, p.redirectTarget, true
+	}
+
+	for _, re := range rm.regexps {
+		if m := re.re.FindStringSubmatchIndex(path); m != nil {
+			return string(re.re.ExpandString(nil, re.to, path, m)), re.redirectTarget, true
+		}
+	}
+
+	return "", redirectTarget{}, false
+}
+
+// hasPathPrefix reports whether path begins with prefix
+// at a path segment boundary, so that /blog matches
+// /blog and /blog/post, but not /blogger.
+func hasPathPrefix(path, prefix string) bool {
+	return strings.HasPrefix(path, prefix) &&
+		(len(path) == len(prefix) ||
+			strings.HasSuffix(prefix, "/") ||
+			path[len(prefix)] == '/')
+}
+
+// isOffSite reports whether to, the result of rewriting
+// a request path with a rule, would redirect to a
+// different scheme, host or userinfo than base, the
+// rule's To, would.
+func isOffSite(to, base string) bool {
+	// Browsers treat a backslash as a slash, so /\host
+	// is scheme relative, though url.Parse disagrees.
+	if strings.HasPrefix(to, "/\\") && !strings.HasPrefix(base, "/\\") {
+		return true
+	}
+
+	u, err := url.Parse(to)
+	if err != nil {
+		return true
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return true
+	}
+
+	return u.Scheme != b.Scheme || u.Host != b.Host ||
+		userinfo(u) != userinfo(b)
+}
+
+func userinfo(u *url.URL) string {
+	if u.User == nil {
+		return ""
+	}
+
+	return u.User.String()
+}
+
+func (rm *redirectMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	to, target, ok := rm.match(r)
+	if !ok {
+		rm.h.ServeHTTP(w, r)
+		return
+	}
+
+	// A prefix or regex rule must not turn the request
+	// path into a redirect to another host, as with
+	// /old//evil.com for a prefix rule from /old/ to /,
+	// or /old/@evil.com for a prefix rule from /old/ to
+	// https://example.com.
+	if to != target.to && isOffSite(to, target.to) {
+		http.Error(w, badRequestText, http.StatusBadRequest)
+		return
+	}
+
+	if !target.dropQuery && r.URL.RawQuery != "" {
+		var fragment string
+		if i := strings.IndexByte(to, '#'); i >= 0 {
+			to, fragment = to[:i], to[i:]
+		}
+
+		if strings.Contains(to, "?") {
+			to += "&" + r.URL.RawQuery + fragment
+		} else {
+			to += "?" + r.URL.RawQuery + fragment
+		}
+	}
+
+	http.Redirect(w, r, to, target.code)
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectMap(t *testing.T) {
+	rules, err := ParseRedirectRulesCSV(strings.NewReader(`from,to,type,code,query
+# Old blog.
+/blog, /news
+/about.html, https://example.com/about, exact, 308, drop
+/docs/, /documentation/, prefix
+/docs/v1/, /archive/v1/, prefix, 302
+"/products/(\d+)\.html", /shop/item/$1?ref=old, regex
+/u/(?P<user>[a-z]+), "/users/${user}#profile", regex, 307
+`))
+	require.NoError(t, err)
+
+	h, err := RedirectMap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	}), rules)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		url      string
+		code     int
+		location string
+	}{
+		{"/blog", http.StatusMovedPermanently, "/news"},
+		{"/blog?page=2", http.StatusMovedPermanently, "/news?page=2"},
+		{"/blog/", 999, ""},
+		{"/about.html?x=1", http.StatusPermanentRedirect, "https://example.com/about"},
+		{"/docs/install", http.StatusMovedPermanently, "/documentation/install"},
+		{"/docs/v1/install?lang=en", http.StatusFound, "/archive/v1/install?lang=en"},
+		{"/products/42.html", http.StatusMovedPermanently, "/shop/item/42?ref=old"},
+		{"/products/42.html?utm=x", http.StatusMovedPermanently, "/shop/item/42?ref=old&utm=x"},
+		{"/products/abc.html", 999, ""},
+		{"/u/alice?tab=1", http.StatusTemporaryRedirect, "/users/alice?tab=1#profile"},
+		{"/other", 999, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.url)
+		assert.Equal(t, tc.location, w.Header().Get("Location"), tc.url)
+	}
+}
+
+func TestRedirectMapJSON(t *testing.T) {
+	rules, err := ParseRedirectRulesJSON(strings.NewReader(`[
+		{"from": "/old", "to": "/new", "code": 303},
+		{"from": "/a/", "to": "/b/", "type": "prefix", "query": "drop"}
+	]`))
+	require.NoError(t, err)
+
+	h, err := RedirectMap(nil, rules)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/old", nil))
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/new", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/c?d", nil))
+
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/b/c", w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestRedirectMapPaths(t *testing.T) {
+	h, err := RedirectMap(nil, []RedirectRule{
+		{From: "/old/", To: "/", Type: "prefix"},
+		{From: "/blog", To: "/news", Type: "prefix"},
+		{From: "/cdn/", To: "//cdn.example.com/", Type: "prefix"},
+		{From: "/go/(.*)", To: "/$1", Type: "regex"},
+		{From: "/moved/", To: "https://new.example.com", Type: "prefix"},
+		{From: "/new/", To: "https://new.example.com/", Type: "prefix"},
+		{From: "/ext/(.*)", To: "https://new.example.com/$1", Type: "regex"},
+		{From: "/any/(.*)", To: "$1", Type: "regex"},
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		url      string
+		code     int
+		location string
+	}{
+		{"/old//evil.com", http.StatusBadRequest, ""},
+		{"/old/%5Cevil.com", http.StatusMovedPermanently, "/%5Cevil.com"},
+		{"/go//evil.com", http.StatusBadRequest, ""},
+		{"/go/\\evil.com", http.StatusBadRequest, ""},
+		{"/go/page", http.StatusMovedPermanently, "/page"},
+		{"/cdn/app.js", http.StatusMovedPermanently, "//cdn.example.com/app.js"},
+		{"/blog", http.StatusMovedPermanently, "/news"},
+		{"/blog/post", http.StatusMovedPermanently, "/news/post"},
+		{"/blogger", http.StatusNotFound, ""},
+		{"/old/a%3Fb=1", http.StatusMovedPermanently, "/a%3Fb=1"},
+		{"/old/a%20b", http.StatusMovedPermanently, "/a%20b"},
+		{"/old/a%2Fb", http.StatusMovedPermanently, "/a%2Fb"},
+		{"/moved/@evil.com/x", http.StatusBadRequest, ""},
+		{"/moved/.evil.com/", http.StatusBadRequest, ""},
+		{"/moved/:8080/", http.StatusBadRequest, ""},
+		{"/moved/page", http.StatusBadRequest, ""},
+		{"/new/page", http.StatusMovedPermanently, "https://new.example.com/page"},
+		{"/new/@evil.com", http.StatusMovedPermanently, "https://new.example.com/@evil.com"},
+		{"/ext/a@evil.com", http.StatusMovedPermanently, "https://new.example.com/a@evil.com"},
+		{"/any/https:%2F%2Fevil.com", http.StatusBadRequest, ""},
+		{"/any/page", http.StatusMovedPermanently, "/any/page"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.url)
+		assert.Equal(t, tc.location, w.Header().Get("Location"), tc.url)
+	}
+}
+
+func TestRedirectMapInvalid(t *testing.T) {
+	for _, rules := range [][]RedirectRule{
+		{{From: "old", To: "/new"}},
+		{{From: "/old"}},
+		{{From: "/old", To: "/new", Code: 200}},
+		{{From: "/old", To: "/new", Type: "glob"}},
+		{{From: "/old", To: "/new", Query: "merge"}},
+		{{From: "(", To: "/new", Type: "regex"}},
+		{{From: "/old", To: "/new"}, {From: "/old", To: "/newer"}},
+		{{From: "/a/", To: "/b/", Type: "prefix"}, {From: "/a/", To: "/c/", Type: "prefix"}},
+	} {
+		_, err := RedirectMap(nil, rules)
+		assert.Error(t, err, "%v", rules)
+	}
+
+	for _, input := range []string{
+		"/old",
+		"/old,/new,exact,3o1",
+		"/a,/b,exact,301,drop,extra",
+		`"/old,/new`,
+	} {
+		_, err := ParseRedirectRulesCSV(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+
+	_, err := ParseRedirectRulesJSON(strings.NewReader(`{"from": "/old"}`))
+	assert.Error(t, err)
+}
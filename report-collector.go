@@ -0,0 +1,392 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is a single report received by ReportCollector.
+type Report struct {
+	// The type of the report, for example
+	// csp-violation or network-error.
+	Type string `json:"type"`
+
+	// The number of milliseconds between the report
+	// being generated and being sent.
+	Age int64 `json:"age,omitempty"`
+
+	// The URL of the document that generated the
+	// report.
+	URL string `json:"url"`
+
+	// The User-Agent of the browser that generated
+	// the report.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// The raw body of the report.
+	Body json.RawMessage `json:"body"`
+
+	// The parsed body of csp-violation reports.
+	CSP *CSPReport `json:"-"`
+
+	// The parsed body of network-error reports.
+	NEL *NELReport `json:"-"`
+}
+
+// CSPReport is the body of a Content-Security-Policy
+// violation report.
+type CSPReport struct {
+	DocumentURL        string `json:"documentURL"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURL         string `json:"blockedURL,omitempty"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	SourceFile         string `json:"sourceFile,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+	Disposition        string `json:"disposition,omitempty"`
+	StatusCode         int    `json:"statusCode,omitempty"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+	ColumnNumber       int    `json:"columnNumber,omitempty"`
+}
+
+// NELReport is the body of a Network Error Logging
+// report.
+type NELReport struct {
+	SamplingFraction float64 `json:"sampling_fraction"`
+	ElapsedTime      int64   `json:"elapsed_time"`
+	Phase            string  `json:"phase"`
+	Type             string  `json:"type"`
+	Referrer         string  `json:"referrer,omitempty"`
+	ServerIP         string  `json:"server_ip,omitempty"`
+	Protocol         string  `json:"protocol,omitempty"`
+	Method           string  `json:"method,omitempty"`
+	StatusCode       int     `json:"status_code,omitempty"`
+}
+
+// legacyCSPReport is the body of an application/csp-report
+// request as sent by the report-uri directive.
+type legacyCSPReport struct {
+	Report *struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		SourceFile         string `json:"source-file"`
+		ScriptSample       string `json:"script-sample"`
+		Disposition        string `json:"disposition"`
+		StatusCode         int    `json:"status-code"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+	} `json:"csp-report"`
+}
+
+// ReportCollector is a http.Handler that receives
+// Content-Security-Policy reports sent by the report-uri
+// directive, as application/csp-report, and Reporting
+// API reports, including NEL and report-to CSP reports,
+// as application/reports+json.
+//
+// It is thread safe and requires no external locks.
+type ReportCollector struct {
+	// Report is invoked for each received report.
+	// It may be invoked concurrently.
+	Report func(*Report)
+
+	// Optionally specifies an io.Writer that each
+	// received report is written to as a single line
+	// of JSON.
+	Writer io.Writer
+
+	// The maximum size of the request body in bytes,
+	// defaults to 64KiB. Larger requests are rejected
+	// with a 413 Request Entity Too Large error.
+	MaxBodySize int64
+
+	// If non-zero, reports that are identical to a
+	// report received less than DedupWindow ago are
+	// dropped.
+	DedupWindow time.Duration
+
+	// Optionally specifies the origins allowed to send
+	// reports with CORS, for when the collector is on a
+	// different origin from the pages it collects
+	// reports for. Browsers send a preflight OPTIONS
+	// request before uploading application/reports+json
+	// reports to a different origin. An origin of *
+	// allows any origin.
+	//
+	// If AllowOrigins is empty, OPTIONS requests are
+	// rejected with a 405 Method Not Allowed error.
+	AllowOrigins []string
+
+	// The methods allowed by a preflight request,
+	// defaults to POST.
+	AllowMethods []string
+
+	// The request headers allowed by a preflight
+	// request, defaults to Content-Type.
+	AllowHeaders []string
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+const (
+	defaultReportMaxBodySize = 64 << 10
+	maxReportDedupEntries    = 10000
+)
+
+// ServeHTTP implements http.Handler.
+func (rc *ReportCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.setCORSHeaders(w.Header(), r)
+
+	switch {
+	case r.Method == http.MethodPost:
+	case r.Method == http.MethodOptions && len(rc.AllowOrigins) != 0:
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, methodNotAllowedText, http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+
+	var parse func([]byte) ([]*Report, error)
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		parse = parseLegacyCSPReport
+	case "application/reports+json":
+		parse = parseReports
+	default:
+		http.Error(w, unsupportedMediaTypeText, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	max := rc.MaxBodySize
+	if max <= 0 {
+		max = defaultReportMaxBodySize
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		http.Error(w, badRequestText, http.StatusBadRequest)
+		return
+	}
+
+	if int64(len(body)) > max {
+		http.Error(w, requestEntityTooLargeText, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	reports, err := parse(body)
+	if err != nil {
+		http.Error(w, badRequestText, http.StatusBadRequest)
+		return
+	}
+
+	for _, report := range reports {
+		if report.UserAgent == "" {
+			report.UserAgent = r.UserAgent()
+		}
+
+		if !rc.isDuplicate(report) {
+			rc.deliver(report)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCORSHeaders sets the CORS response headers if the
+// request's Origin is allowed by AllowOrigins.
+func (rc *ReportCollector) setCORSHeaders(h http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(rc.AllowOrigins) == 0 {
+		return
+	}
+
+	allowed := ""
+	for _, o := range rc.AllowOrigins {
+		if o == "*" || o == origin {
+			allowed = o
+			break
+		}
+	}
+
+	if allowed != "*" {
+		h.Add("Vary", "Origin")
+	}
+
+	if allowed == "" {
+		return
+	}
+
+	h.Set("Access-Control-Allow-Origin", allowed)
+
+	if r.Method != http.MethodOptions {
+		return
+	}
+
+	methods := rc.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost}
+	}
+
+	headers := rc.AllowHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+}
+
+func (rc *ReportCollector) isDuplicate(report *Report) bool {
+	if rc.DedupWindow <= 0 {
+		return false
+	}
+
+	key := report.Type + "\x00" + report.URL + "\x00" + string(report.Body)
+	now := time.Now()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if last, ok := rc.seen[key]; ok && now.Sub(last) < rc.DedupWindow {
+		return true
+	}
+
+	if rc.seen == nil {
+		rc.seen = make(map[string]time.Time)
+	}
+
+	if len(rc.seen) >= maxReportDedupEntries {
+		for k, last := range rc.seen {
+			if now.Sub(last) >= rc.DedupWindow {
+				delete(rc.seen, k)
+			}
+		}
+	}
+
+	if len(rc.seen) < maxReportDedupEntries {
+		rc.seen[key] = now
+	}
+
+	return false
+}
+
+func (rc *ReportCollector) deliver(report *Report) {
+	if rc.Writer != nil {
+		rc.mu.Lock()
+		json.NewEncoder(rc.Writer).Encode(report)
+		rc.mu.Unlock()
+	}
+
+	if rc.Report != nil {
+		rc.Report(report)
+	}
+}
+
+func parseLegacyCSPReport(body []byte) ([]*Report, error) {
+	var legacy legacyCSPReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+
+	lr := legacy.Report
+	if lr == nil || lr.DocumentURI == "" {
+		return nil, errInvalidReport
+	}
+
+	directive := lr.EffectiveDirective
+	if directive == "" {
+		directive = lr.ViolatedDirective
+	}
+
+	csp := &CSPReport{
+		DocumentURL:        lr.DocumentURI,
+		Referrer:           lr.Referrer,
+		BlockedURL:         lr.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     lr.OriginalPolicy,
+		SourceFile:         lr.SourceFile,
+		Sample:             lr.ScriptSample,
+		Disposition:        lr.Disposition,
+		StatusCode:         lr.StatusCode,
+		LineNumber:         lr.LineNumber,
+		ColumnNumber:       lr.ColumnNumber,
+	}
+
+	raw, err := json.Marshal(csp)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Report{{
+		Type: "csp-violation",
+		URL:  lr.DocumentURI,
+		Body: raw,
+		CSP:  csp,
+	}}, nil
+}
+
+func parseReports(body []byte) ([]*Report, error) {
+	var reports []*Report
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil, err
+	}
+
+	for _, report := range reports {
+		if report == nil || report.Type == "" || len(report.Body) == 0 ||
+			bytes.Equal(report.Body, []byte("null")) {
+			return nil, errInvalidReport
+		}
+
+		var v interface{}
+		switch report.Type {
+		case "csp-violation":
+			report.CSP = new(CSPReport)
+			v = report.CSP
+		case "network-error":
+			report.NEL = new(NELReport)
+			v = report.NEL
+		default:
+			continue
+		}
+
+		if err := json.Unmarshal(report.Body, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return reports, nil
+}
+
+var errInvalidReport = errors.New("handlers: invalid report")
+
+var (
+	methodNotAllowedText      = http.StatusText(http.StatusMethodNotAllowed)
+	unsupportedMediaTypeText  = http.StatusText(http.StatusUnsupportedMediaType)
+	requestEntityTooLargeText = http.StatusText(http.StatusRequestEntityTooLarge)
+)
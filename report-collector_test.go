@@ -0,0 +1,250 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLegacyCSPReport = `{"csp-report":{
+	"document-uri":"https://example.com/page",
+	"referrer":"",
+	"violated-directive":"script-src-elem",
+	"effective-directive":"script-src-elem",
+	"original-policy":"script-src 'self'; report-uri /csp",
+	"disposition":"enforce",
+	"blocked-uri":"https://evil.example.net/x.js",
+	"line-number":10,
+	"status-code":200
+}}`
+
+const testReportsJSON = `[{
+	"type":"csp-violation",
+	"age":10,
+	"url":"https://example.com/page",
+	"user_agent":"Test/1.0",
+	"body":{
+		"documentURL":"https://example.com/page",
+		"blockedURL":"inline",
+		"effectiveDirective":"style-src-elem",
+		"originalPolicy":"style-src 'self'",
+		"disposition":"report",
+		"statusCode":200
+	}
+},{
+	"type":"network-error",
+	"age":20,
+	"url":"https://example.com/",
+	"body":{
+		"sampling_fraction":1.0,
+		"elapsed_time":45,
+		"phase":"connection",
+		"type":"tcp.reset",
+		"server_ip":"192.0.2.1",
+		"protocol":"h2",
+		"method":"GET"
+	}
+},{
+	"type":"deprecation",
+	"url":"https://example.com/",
+	"body":{"id":"x"}
+}]`
+
+func TestReportCollectorLegacyCSP(t *testing.T) {
+	var reports []*Report
+	rc := &ReportCollector{
+		Report: func(r *Report) { reports = append(reports, r) },
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/csp", strings.NewReader(testLegacyCSPReport))
+	r.Header.Set("Content-Type", "application/csp-report")
+	r.Header.Set("User-Agent", "Test/2.0")
+
+	w := httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, reports, 1)
+
+	assert.Equal(t, "csp-violation", reports[0].Type)
+	assert.Equal(t, "https://example.com/page", reports[0].URL)
+	assert.Equal(t, "Test/2.0", reports[0].UserAgent)
+	assert.Equal(t, &CSPReport{
+		DocumentURL:        "https://example.com/page",
+		BlockedURL:         "https://evil.example.net/x.js",
+		EffectiveDirective: "script-src-elem",
+		OriginalPolicy:     "script-src 'self'; report-uri /csp",
+		Disposition:        "enforce",
+		StatusCode:         200,
+		LineNumber:         10,
+	}, reports[0].CSP)
+}
+
+func TestReportCollectorReports(t *testing.T) {
+	var reports []*Report
+	var buf bytes.Buffer
+	rc := &ReportCollector{
+		Report: func(r *Report) { reports = append(reports, r) },
+		Writer: &buf,
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(testReportsJSON))
+	r.Header.Set("Content-Type", "application/reports+json; charset=utf-8")
+
+	w := httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	require.Len(t, reports, 3)
+
+	assert.Equal(t, "Test/1.0", reports[0].UserAgent)
+	require.NotNil(t, reports[0].CSP)
+	assert.Equal(t, "style-src-elem", reports[0].CSP.EffectiveDirective)
+	assert.Nil(t, reports[0].NEL)
+
+	require.NotNil(t, reports[1].NEL)
+	assert.Equal(t, "tcp.reset", reports[1].NEL.Type)
+	assert.Equal(t, "192.0.2.1", reports[1].NEL.ServerIP)
+	assert.Nil(t, reports[1].CSP)
+
+	assert.Equal(t, "deprecation", reports[2].Type)
+	assert.JSONEq(t, `{"id":"x"}`, string(reports[2].Body))
+
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `"type":"network-error"`)
+}
+
+func TestReportCollectorErrors(t *testing.T) {
+	rc := &ReportCollector{
+		Report:      func(r *Report) { t.Error("Report called for invalid request") },
+		MaxBodySize: 64,
+	}
+
+	for _, tc := range []struct {
+		method, ctype, body string
+		code                int
+	}{
+		{http.MethodGet, "application/csp-report", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "text/plain", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "", "{}", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "application/csp-report", "{", http.StatusBadRequest},
+		{http.MethodPost, "application/csp-report", "{}", http.StatusBadRequest},
+		{http.MethodPost, "application/reports+json", "{}", http.StatusBadRequest},
+		{http.MethodPost, "application/reports+json", `[{"url":"x","body":{}}]`, http.StatusBadRequest},
+		{http.MethodPost, "application/reports+json", `[{"type":"x","body":null}]`, http.StatusBadRequest},
+		{http.MethodPost, "application/reports+json", strings.Repeat(" ", 65) + "[]", http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(tc.method, "/", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.ctype)
+
+		w := httptest.NewRecorder()
+		rc.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, "%s %q %q", tc.method, tc.ctype, tc.body)
+	}
+}
+
+func TestReportCollectorCORS(t *testing.T) {
+	var count int
+	rc := &ReportCollector{
+		Report:       func(*Report) { count++ },
+		AllowOrigins: []string{"https://example.com"},
+	}
+
+	r := httptest.NewRequest(http.MethodOptions, "https://report.example.com/", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+
+	w := httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	r.Header.Set("Origin", "https://attacker.example")
+
+	w = httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	r = httptest.NewRequest(http.MethodPost, "https://report.example.com/", strings.NewReader(
+		`[{"type":"network-error","url":"https://example.com/","body":{}}]`))
+	r.Header.Set("Content-Type", "application/reports+json")
+	r.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, 1, count)
+
+	rc = &ReportCollector{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{http.MethodPost, http.MethodOptions},
+		AllowHeaders: []string{"Content-Type", "X-Request-Id"},
+	}
+
+	r = httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "https://example.org")
+
+	w = httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "POST, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-Request-Id", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	w = httptest.NewRecorder()
+	new(ReportCollector).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestReportCollectorDedup(t *testing.T) {
+	var count int
+	rc := &ReportCollector{
+		Report:      func(r *Report) { count++ },
+		DedupWindow: time.Hour,
+	}
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/csp", strings.NewReader(testLegacyCSPReport))
+		r.Header.Set("Content-Type", "application/csp-report")
+
+		w := httptest.NewRecorder()
+		rc.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	}
+
+	assert.Equal(t, 1, count, "duplicate reports not dropped")
+
+	r := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(testReportsJSON))
+	r.Header.Set("Content-Type", "application/reports+json")
+
+	w := httptest.NewRecorder()
+	rc.ServeHTTP(w, r)
+
+	assert.Equal(t, 4, count)
+}
@@ -7,52 +7,382 @@ package handlers
 
 import (
 	"fmt"
+	"net"
 	"net/http"
-	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
+// HostSwitchOp is a change made to a SafeHostSwitch.
+type HostSwitchOp int
+
+// The changes reported to SafeHostSwitch.OnChange.
+const (
+	HostAdded HostSwitchOp = iota
+	HostRemoved
+	HostReplaced
+)
+
+func (op HostSwitchOp) String() string {
+	switch op {
+	case HostAdded:
+		return "added"
+	case HostRemoved:
+		return "removed"
+	case HostReplaced:
+		return "replaced"
+	default:
+		return fmt.Sprintf("HostSwitchOp(%d)", int(op))
+	}
+}
+
 // SafeHostSwitch is a http.Handler that routes
 // the request based on the Host header. It is
 // thread safe and requites no external locks.
+//
+// Hosts may have a leading wildcard label or a port
+// and are normalized, as with HostSwitch.
 type SafeHostSwitch struct {
-	m sync.Map
+	mu     sync.Mutex
+	routes atomic.Value // *safeHostRoutes
 
 	// NotFound is invoked for hosts
 	// that have not been added to the
 	// host switch.
 	NotFound http.Handler
+
+	// ListenerPort matches entries with a port
+	// against the port of the local address the
+	// request was received on, rather than the port
+	// of the Host header.
+	ListenerPort bool
+
+	// OnChange is optionally called for every host
+	// that is added, removed or replaced, with the
+	// normalized host. It is called synchronously,
+	// in order, and must not modify the host switch.
+	OnChange func(op HostSwitchOp, host string)
+}
+
+// safeHostRoutes is the routing table of SafeHostSwitch.
+type safeHostRoutes struct {
+	// exact holds the exact entries without a port,
+	// which are added and removed in place.
+	exact *sync.Map // map[string]http.Handler
+
+	// routes holds the wildcard entries and the entries
+	// with a port. It is copied on write with cloneFor.
+	routes *hostRoutes
+}
+
+func newSafeHostRoutes() *safeHostRoutes {
+	return &safeHostRoutes{new(sync.Map), new(hostRoutes)}
+}
+
+func isExactHost(host, port string) bool {
+	return port == "" && !strings.HasPrefix(host, "*.")
+}
+
+// add adds h to the routing table, modifying it in
+// place, and reports whether the host had already been
+// added.
+func (sr *safeHostRoutes) add(host, port string, h http.Handler) (dup bool) {
+	if isExactHost(host, port) {
+		_, dup = sr.exact.LoadOrStore(host, h)
+		return dup
+	}
+
+	return sr.routes.add(host, port, h)
+}
+
+func (sr *safeHostRoutes) each(f func(host string, h http.Handler)) {
+	sr.exact.Range(func(host, h interface{}) bool {
+		f(host.(string), h.(http.Handler))
+		return true
+	})
+
+	sr.routes.each(f)
+}
+
+func (sr *safeHostRoutes) lookup(host, port string) (h http.Handler, wildcard string, ok bool) {
+	if pr := sr.routes.ports[port]; pr != nil && port != "" {
+		if h, wildcard, ok := pr.lookup(host, ""); ok {
+			return h, wildcard, ok
+		}
+	}
+
+	if h, ok := sr.exact.Load(host); ok {
+		return h.(http.Handler), "", true
+	}
+
+	return sr.routes.lookupWildcard(host)
+}
+
+func (hs *SafeHostSwitch) load() *safeHostRoutes {
+	routes, _ := hs.routes.Load().(*safeHostRoutes)
+	if routes == nil {
+		return newSafeHostRoutes()
+	}
+
+	return routes
+}
+
+// loadLocked is like load but stores the routing table
+// if it is empty, so that exact entries may be added to
+// it in place. hs.mu must be held.
+func (hs *SafeHostSwitch) loadLocked() *safeHostRoutes {
+	routes, _ := hs.routes.Load().(*safeHostRoutes)
+	if routes == nil {
+		routes = newSafeHostRoutes()
+		hs.routes.Store(routes)
+	}
+
+	return routes
+}
+
+func (hs *SafeHostSwitch) changed(op HostSwitchOp, host, port string) {
+	if hs.OnChange == nil {
+		return
+	}
+
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	hs.OnChange(op, host)
 }
 
 // Add adds a http.Handler to the host switch.
 //
-// It returns an error if the host has already been
-// added.
+// Adding an exact host without a port takes constant
+// time. Adding a wildcard host or a host with a port
+// copies part of the routing table, so SetAll should be
+// preferred for adding many such hosts at once.
+//
+// It returns an error if the host is invalid or has
+// already been added.
 func (hs *SafeHostSwitch) Add(host string, h http.Handler) error {
-	if _, dup := hs.m.LoadOrStore(host, h); dup {
-		return fmt.Errorf("handlers: a handle is already registered for host %q", host)
+	norm, port, err := parseHostPattern(host)
+	if err != nil {
+		return err
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	sr := hs.loadLocked()
+	if isExactHost(norm, port) {
+		if sr.add(norm, port, h) {
+			return fmt.Errorf("handlers: a handle is already registered for host %q", host)
+		}
+	} else {
+		routes := sr.routes.cloneFor(norm, port)
+		if routes.add(norm, port, h) {
+			return fmt.Errorf("handlers: a handle is already registered for host %q", host)
+		}
+
+		hs.routes.Store(&safeHostRoutes{sr.exact, routes})
+	}
+
+	hs.changed(HostAdded, norm, port)
+	return nil
+}
+
+// Replace replaces the http.Handler for a host, or adds
+// it if the host has not been added.
+//
+// It returns an error if the host is invalid.
+func (hs *SafeHostSwitch) Replace(host string, h http.Handler) error {
+	norm, port, err := parseHostPattern(host)
+	if err != nil {
+		return err
 	}
 
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	sr := hs.loadLocked()
+
+	op := HostAdded
+	if isExactHost(norm, port) {
+		if _, ok := sr.exact.Load(norm); ok {
+			op = HostReplaced
+		}
+
+		sr.exact.Store(norm, h)
+	} else {
+		routes := sr.routes.cloneFor(norm, port)
+		if routes.remove(norm, port) {
+			op = HostReplaced
+		}
+
+		routes.add(norm, port, h)
+		hs.routes.Store(&safeHostRoutes{sr.exact, routes})
+	}
+
+	hs.changed(op, norm, port)
 	return nil
 }
 
 // Remove removes a http.Handler from the host switch.
 func (hs *SafeHostSwitch) Remove(host string) {
-	hs.m.Delete(host)
+	host, port, err := parseHostPattern(host)
+	if err != nil {
+		return
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	sr := hs.loadLocked()
+	if isExactHost(host, port) {
+		if _, ok := sr.exact.Load(host); !ok {
+			return
+		}
+
+		sr.exact.Delete(host)
+	} else {
+		routes := sr.routes.cloneFor(host, port)
+		if !routes.remove(host, port) {
+			return
+		}
+
+		hs.routes.Store(&safeHostRoutes{sr.exact, routes})
+	}
+
+	hs.changed(HostRemoved, host, port)
 }
 
-// ServeHTTP implements http.Handler.
-func (hs *SafeHostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	host := (&url.URL{Host: r.Host}).Hostname()
+// SetAll atomically replaces every host in the host
+// switch with those in hosts. Concurrent requests see
+// either the old or the new hosts, never a mix of both.
+//
+// It returns an error, and leaves the host switch
+// unchanged, if any host is invalid or if two hosts are
+// the same after normalization.
+func (hs *SafeHostSwitch) SetAll(hosts map[string]http.Handler) error {
+	// Sort the hosts so that any error is
+	// deterministic.
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
 
-	if h, ok := hs.m.Load(host); ok {
-		h.(http.Handler).ServeHTTP(w, r)
-		return
+	sort.Strings(names)
+
+	routes := newSafeHostRoutes()
+	for _, host := range names {
+		norm, port, err := parseHostPattern(host)
+		if err != nil {
+			return err
+		}
+
+		if routes.add(norm, port, hosts[host]) {
+			return fmt.Errorf("handlers: a handle is already registered for host %q", host)
+		}
 	}
 
-	if hs.NotFound != nil {
-		hs.NotFound.ServeHTTP(w, r)
-	} else {
-		http.Error(w, forbiddenText, http.StatusForbidden)
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	old := hs.load()
+	hs.routes.Store(routes)
+
+	if hs.OnChange == nil {
+		return nil
+	}
+
+	prev := make(map[string]bool)
+	old.each(func(host string, _ http.Handler) {
+		prev[host] = true
+	})
+
+	for _, e := range sortedEntries(routes) {
+		host := e.host
+		if prev[host] {
+			delete(prev, host)
+			hs.OnChange(HostReplaced, host)
+		} else {
+			hs.OnChange(HostAdded, host)
+		}
 	}
+
+	removed := make([]string, 0, len(prev))
+	for host := range prev {
+		removed = append(removed, host)
+	}
+
+	sort.Strings(removed)
+
+	for _, host := range removed {
+		hs.OnChange(HostRemoved, host)
+	}
+
+	return nil
+}
+
+// Range calls f for each host in the host switch, in
+// sorted order, until f returns false. The hosts are
+// normalized and include any wildcard label or port.
+//
+// The hosts are collected, in a single pass, before f is
+// called. Changes made concurrently, or by f, are not
+// seen by f.
+func (hs *SafeHostSwitch) Range(f func(host string, h http.Handler) bool) {
+	for _, e := range hs.entries() {
+		if !f(e.host, e.h) {
+			return
+		}
+	}
+}
+
+// Hosts returns the sorted hosts in the host switch. The
+// hosts are normalized and include any wildcard label or
+// port.
+func (hs *SafeHostSwitch) Hosts() []string {
+	entries := hs.entries()
+
+	hosts := make([]string, len(entries))
+	for i, e := range entries {
+		hosts[i] = e.host
+	}
+
+	return hosts
+}
+
+type hostEntry struct {
+	host string
+	h    http.Handler
+}
+
+// entries returns the sorted entries of the host switch.
+// It holds hs.mu so that the entries are consistent with
+// every completed Add, Replace, Remove and SetAll, as the
+// exact entries are modified in place.
+func (hs *SafeHostSwitch) entries() []hostEntry {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return sortedEntries(hs.load())
+}
+
+func sortedEntries(routes *safeHostRoutes) []hostEntry {
+	var entries []hostEntry
+	routes.each(func(host string, h http.Handler) {
+		entries = append(entries, hostEntry{host, h})
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].host < entries[j].host
+	})
+
+	return entries
+}
+
+// ServeHTTP implements http.Handler.
+func (hs *SafeHostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, wildcard, ok := hs.load().lookup(requestHost(r), requestPort(r, hs.ListenerPort))
+	serveHostRoute(w, r, h, wildcard, ok, hs.NotFound)
 }
@@ -6,6 +6,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -20,6 +21,9 @@ func TestSafeHostSwitchAdd(t *testing.T) {
 	assert.NoError(t, hs.Add("example.org", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
 
 	assert.Error(t, hs.Add("example.com", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	assert.NoError(t, hs.Add("", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	assert.Error(t, hs.Add("", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
 }
 
 func TestSafeHostSwitchRemove(t *testing.T) {
@@ -82,3 +86,247 @@ func TestSafeHostSwitch(t *testing.T) {
 
 	assert.Equal(t, 997, w.Code, "SafeHostSwitch invoked incorrect http.Handler")
 }
+
+func TestSafeHostSwitchWildcard(t *testing.T) {
+	var hs SafeHostSwitch
+
+	handler := func(code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Wildcard", HostWildcardFromContext(r.Context()))
+			w.WriteHeader(code)
+		})
+	}
+
+	assert.NoError(t, hs.Add("*.example.com", handler(997)))
+	assert.NoError(t, hs.Add("*.eu.example.com", handler(998)))
+	assert.Error(t, hs.Add("*.example.com", handler(999)))
+	assert.Error(t, hs.Add("a.*.example.com", handler(999)))
+
+	for _, tc := range []struct {
+		host     string
+		code     int
+		wildcard string
+	}{
+		{"www.example.com", 997, "www"},
+		{"acme.eu.example.com", 998, "acme"},
+		{"example.com", http.StatusForbidden, ""},
+	} {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+tc.host+"/", nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.host)
+		assert.Equal(t, tc.wildcard, w.Header().Get("X-Wildcard"), tc.host)
+	}
+
+	hs.Remove("*.eu.example.com")
+
+	w := httptest.NewRecorder()
+	hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://acme.eu.example.com/", nil))
+
+	assert.Equal(t, 997, w.Code)
+	assert.Equal(t, "acme.eu", w.Header().Get("X-Wildcard"))
+}
+
+func TestSafeHostSwitchNormalize(t *testing.T) {
+	var hs SafeHostSwitch
+
+	assert.NoError(t, hs.Add("Example.COM.", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(997)
+	})))
+	assert.Error(t, hs.Add("example.com", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	assert.Error(t, hs.Add("exa mple.com", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	r := httptest.NewRequest(http.MethodGet, "http://EXAMPLE.com./", nil)
+
+	w := httptest.NewRecorder()
+	hs.ServeHTTP(w, r)
+
+	assert.Equal(t, 997, w.Code)
+
+	hs.Remove("example.COM")
+
+	w = httptest.NewRecorder()
+	hs.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSafeHostSwitchPort(t *testing.T) {
+	var hs SafeHostSwitch
+
+	assert.NoError(t, hs.Add("example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(997)
+	})))
+	assert.NoError(t, hs.Add("example.com:8443", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(998)
+	})))
+	assert.Error(t, hs.Add("example.com:8443", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	assert.Error(t, hs.Add("example.com:http", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com:8443/", nil)
+
+	w := httptest.NewRecorder()
+	hs.ServeHTTP(w, r)
+
+	assert.Equal(t, 998, w.Code)
+
+	hs.Remove("example.com:8443")
+
+	w = httptest.NewRecorder()
+	hs.ServeHTTP(w, r)
+
+	assert.Equal(t, 997, w.Code)
+}
+
+func TestSafeHostSwitchBulk(t *testing.T) {
+	var changes []string
+	hs := &SafeHostSwitch{
+		OnChange: func(op HostSwitchOp, host string) {
+			changes = append(changes, op.String()+" "+host)
+		},
+	}
+
+	handler := func(code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		})
+	}
+
+	serve := func(host string) int {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil))
+		return w.Code
+	}
+
+	assert.NoError(t, hs.Add("example.com", handler(990)))
+	assert.NoError(t, hs.Replace("example.com", handler(991)))
+	assert.NoError(t, hs.Replace("Example.org", handler(992)))
+	assert.Error(t, hs.Replace("exa mple.org", handler(999)))
+
+	assert.Equal(t, 991, serve("example.com"))
+	assert.Equal(t, 992, serve("example.org"))
+
+	assert.NoError(t, hs.SetAll(map[string]http.Handler{
+		"example.com":        handler(993),
+		"*.example.com":      handler(994),
+		"example.net:8080":   handler(995),
+		"[2001:db8::1]:8443": handler(996),
+	}))
+
+	assert.Equal(t, 993, serve("example.com"))
+	assert.Equal(t, 994, serve("www.example.com"))
+	assert.Equal(t, 995, serve("example.net:8080"))
+	assert.Equal(t, http.StatusForbidden, serve("example.org"))
+
+	assert.Equal(t, []string{"*.example.com", "[2001:db8::1]:8443", "example.com", "example.net:8080"}, hs.Hosts())
+
+	var hosts []string
+	hs.Range(func(host string, h http.Handler) bool {
+		hosts = append(hosts, host)
+		return len(hosts) < 2
+	})
+	assert.Equal(t, []string{"*.example.com", "[2001:db8::1]:8443"}, hosts)
+
+	assert.Error(t, hs.SetAll(map[string]http.Handler{
+		"example.com": handler(999),
+		"EXAMPLE.com": handler(999),
+	}))
+	assert.Error(t, hs.SetAll(map[string]http.Handler{
+		"example.com":  handler(999),
+		"exa mple.com": handler(999),
+	}))
+	assert.Equal(t, 993, serve("example.com"))
+
+	hs.Remove("*.example.com")
+	hs.Remove("*.example.com")
+
+	assert.Equal(t, []string{
+		"added example.com",
+		"replaced example.com",
+		"added example.org",
+		"added *.example.com",
+		"added [2001:db8::1]:8443",
+		"replaced example.com",
+		"added example.net:8080",
+		"removed example.org",
+		"removed *.example.com",
+	}, changes)
+}
+
+func TestSafeHostSwitchCopyOnWrite(t *testing.T) {
+	var hs SafeHostSwitch
+
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	assert.NoError(t, hs.Add("*.example.com", handler))
+	assert.NoError(t, hs.Add("example.com:8080", handler))
+
+	before := hs.load()
+
+	assert.NoError(t, hs.Add("*.eu.example.com", handler))
+	assert.NoError(t, hs.Add("example.org:8080", handler))
+	hs.Remove("*.example.com")
+
+	_, wildcard, ok := before.lookup("a.eu.example.com", "")
+	assert.True(t, ok)
+	assert.Equal(t, "a.eu", wildcard)
+
+	_, _, ok = before.lookup("example.org", "8080")
+	assert.False(t, ok)
+
+	var hosts []string
+	for _, e := range sortedEntries(before) {
+		hosts = append(hosts, e.host)
+	}
+	assert.Equal(t, []string{"*.example.com", "example.com:8080"}, hosts)
+	assert.Equal(t, []string{"*.eu.example.com", "example.com:8080", "example.org:8080"}, hs.Hosts())
+}
+
+func TestSafeHostSwitchRangeConcurrent(t *testing.T) {
+	var hs SafeHostSwitch
+
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			hs.Add(fmt.Sprintf("host%d.example.com", i), handler)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		hs.Range(func(host string, h http.Handler) bool {
+			if h == nil {
+				t.Errorf("Range passed a nil handler for %s", host)
+			}
+
+			return true
+		})
+	}
+
+	assert.Len(t, hs.Hosts(), 1000)
+}
+
+func BenchmarkSafeHostSwitchAdd(b *testing.B) {
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	for _, pattern := range []string{"host%d.example.com", "*.host%d.example.com"} {
+		b.Run(pattern, func(b *testing.B) {
+			var hs SafeHostSwitch
+
+			for i := 0; i < b.N; i++ {
+				if err := hs.Add(fmt.Sprintf(pattern, i), handler); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditSeverity is the severity of an AuditFinding.
+type AuditSeverity int
+
+// The severities of an AuditFinding.
+const (
+	// AuditInfo is informational and does not affect
+	// the grade.
+	AuditInfo AuditSeverity = iota
+
+	// AuditWarning is a weak or deprecated
+	// configuration.
+	AuditWarning
+
+	// AuditError is a missing or ineffective header.
+	AuditError
+)
+
+func (s AuditSeverity) String() string {
+	switch s {
+	case AuditInfo:
+		return "info"
+	case AuditWarning:
+		return "warning"
+	case AuditError:
+		return "error"
+	default:
+		return "AuditSeverity(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// AuditFinding is a single finding of AuditHeaders.
+type AuditFinding struct {
+	// The header the finding relates to.
+	Header string
+
+	Severity AuditSeverity
+
+	// A human readable description of the finding.
+	Message string
+
+	// The number of points deducted from the score.
+	Penalty int
+}
+
+// AuditResult is the result of AuditHeaders.
+type AuditResult struct {
+	Findings []AuditFinding
+
+	// The score out of 100.
+	Score int
+
+	// The overall grade, from A+ to F.
+	Grade string
+}
+
+func (ar *AuditResult) add(header string, sev AuditSeverity, penalty int, msg string) {
+	ar.Findings = append(ar.Findings, AuditFinding{header, sev, msg, penalty})
+	ar.Score -= penalty
+}
+
+// HSTSRecommendedMinMaxAge is the minimum
+// Strict-Transport-Security max-age below which
+// AuditHeaders reports a warning.
+const HSTSRecommendedMinMaxAge = 180 * 24 * time.Hour
+
+// AuditHeaders evaluates the security headers of a
+// response, in a similar fashion to securityheaders.com,
+// and returns the findings with an overall grade.
+//
+// secure should report whether the response was served
+// over TLS. Strict-Transport-Security is only evaluated
+// for secure responses.
+//
+// The checks and the grading are intended for
+// development and testing and may change.
+func AuditHeaders(h http.Header, secure bool) *AuditResult {
+	ar := &AuditResult{Score: 100}
+
+	auditCSP(ar, h)
+	auditHSTS(ar, h, secure)
+
+	if h.Get("X-Frame-Options") == "" && !strings.Contains(strings.ToLower(h.Get("Content-Security-Policy")), "frame-ancestors") {
+		ar.add("X-Frame-Options", AuditError, 15, "missing X-Frame-Options and no CSP frame-ancestors directive")
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(h.Get("X-Content-Type-Options")), "nosniff") {
+		ar.add("X-Content-Type-Options", AuditError, 15, "missing X-Content-Type-Options: nosniff")
+	}
+
+	switch rp := strings.ToLower(h.Get("Referrer-Policy")); {
+	case rp == "":
+		ar.add("Referrer-Policy", AuditError, 10, "missing Referrer-Policy")
+	case strings.Contains(rp, "unsafe-url"), strings.Contains(rp, "no-referrer-when-downgrade"):
+		ar.add("Referrer-Policy", AuditWarning, 5, "Referrer-Policy leaks the full URL to other origins")
+	}
+
+	if h.Get("Permissions-Policy") == "" {
+		ar.add("Permissions-Policy", AuditError, 10, "missing Permissions-Policy")
+	}
+
+	if xss := strings.TrimSpace(h.Get("X-Xss-Protection")); xss != "" && xss != "0" {
+		ar.add("X-Xss-Protection", AuditWarning, 5, "X-XSS-Protection should be 0 as the XSS auditor can introduce vulnerabilities")
+	}
+
+	for _, deprecated := range []struct {
+		header, msg string
+		sev         AuditSeverity
+	}{
+		{"Expect-Ct", "Expect-CT is deprecated and ignored by browsers", AuditInfo},
+		{"Feature-Policy", "Feature-Policy is deprecated, use Permissions-Policy", AuditInfo},
+		{"Public-Key-Pins", "Public-Key-Pins is deprecated and dangerous", AuditWarning},
+	} {
+		if h.Get(deprecated.header) != "" {
+			penalty := 0
+			if deprecated.sev == AuditWarning {
+				penalty = 5
+			}
+
+			ar.add(deprecated.header, deprecated.sev, penalty, deprecated.msg)
+		}
+	}
+
+	for _, leak := range []string{"Server", "X-Powered-By", "X-Aspnet-Version"} {
+		if v := h.Get(leak); v != "" && strings.ContainsAny(v, "0123456789") {
+			ar.add(leak, AuditInfo, 0, leak+" may disclose software versions")
+		}
+	}
+
+	if ar.Score < 0 {
+		ar.Score = 0
+	}
+
+	ar.Grade = auditGrade(ar)
+	return ar
+}
+
+func auditGrade(ar *AuditResult) string {
+	switch {
+	case ar.Score == 100:
+		for _, f := range ar.Findings {
+			if f.Severity != AuditInfo {
+				return "A"
+			}
+		}
+
+		return "A+"
+	case ar.Score >= 90:
+		return "A"
+	case ar.Score >= 80:
+		return "B"
+	case ar.Score >= 70:
+		return "C"
+	case ar.Score >= 60:
+		return "D"
+	case ar.Score >= 50:
+		return "E"
+	default:
+		return "F"
+	}
+}
+
+func auditCSP(ar *AuditResult, h http.Header) {
+	const name = "Content-Security-Policy"
+
+	policy := h.Get(name)
+	if policy == "" {
+		ar.add(name, AuditError, 25, "missing Content-Security-Policy")
+		return
+	}
+
+	if _, err := ParseCSP(policy); err != nil {
+		ar.add(name, AuditWarning, 5, "malformed Content-Security-Policy: "+err.Error())
+	}
+
+	directives := make(map[string][]string)
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(strings.ToLower(directive))
+		if len(fields) != 0 {
+			if _, dup := directives[fields[0]]; !dup {
+				directives[fields[0]] = fields[1:]
+			}
+		}
+	}
+
+	script, ok := directives["script-src"]
+	if !ok {
+		script, ok = directives["default-src"]
+	}
+
+	if !ok {
+		ar.add(name, AuditWarning, 10, "Content-Security-Policy does not restrict scripts with script-src or default-src")
+		return
+	}
+
+	var nonceOrHash, unsafeInline bool
+	for _, src := range script {
+		switch {
+		case src == "'unsafe-inline'":
+			unsafeInline = true
+		case src == "'unsafe-eval'":
+			ar.add(name, AuditWarning, 5, "script sources allow 'unsafe-eval'")
+		case src == "*", src == "http:", src == "https:", src == "data:":
+			ar.add(name, AuditWarning, 10, "script sources allow "+src)
+		case strings.HasPrefix(src, "'nonce-"), strings.HasPrefix(src, "'sha"):
+			nonceOrHash = true
+		}
+	}
+
+	// Browsers ignore 'unsafe-inline' when a nonce or
+	// hash is present, it is only included for
+	// backwards compatibility.
+	if unsafeInline && !nonceOrHash {
+		ar.add(name, AuditWarning, 10, "script sources allow 'unsafe-inline'")
+	}
+}
+
+func auditHSTS(ar *AuditResult, h http.Header, secure bool) {
+	const name = "Strict-Transport-Security"
+
+	if !secure {
+		ar.add(name, AuditInfo, 0, "response is not secure, Strict-Transport-Security not evaluated")
+		return
+	}
+
+	hsts := h.Get(name)
+	if hsts == "" {
+		ar.add(name, AuditError, 20, "missing Strict-Transport-Security")
+		return
+	}
+
+	maxAge := int64(-1)
+	for _, directive := range strings.Split(hsts, ";") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) > 8 && strings.EqualFold(directive[:8], "max-age=") {
+			v, err := strconv.ParseInt(strings.Trim(directive[8:], `"`), 10, 64)
+			if err == nil {
+				maxAge = v
+			}
+		}
+	}
+
+	switch {
+	case maxAge < 0:
+		ar.add(name, AuditError, 20, "Strict-Transport-Security has no valid max-age")
+	case maxAge == 0:
+		ar.add(name, AuditError, 20, "Strict-Transport-Security max-age=0 disables HSTS")
+	case maxAge < int64(HSTSRecommendedMinMaxAge/time.Second):
+		ar.add(name, AuditWarning, 10, "Strict-Transport-Security max-age is less than six months")
+	}
+}
+
+// AuditLog wraps a http.Handler and logs the result of
+// AuditHeaders for every response to an io.Writer that
+// defaults to os.Stderr.
+//
+// It is intended for use during development, to catch
+// missing or weak security headers, and may not be
+// stable.
+func AuditLog(h http.Handler, out io.Writer) Handler {
+	if out == nil {
+		out = os.Stderr
+	}
+
+	return &auditLog{h, out}
+}
+
+// AuditLogWrap returns a Middleware that calls AuditLog.
+func AuditLogWrap(out io.Writer) Middleware {
+	return func(h http.Handler) http.Handler {
+		return AuditLog(h, out)
+	}
+}
+
+type auditLog struct {
+	h   http.Handler
+	out io.Writer
+}
+
+func (al *auditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw, hw := newHookResponseWriter(w, func(code int, sniff []byte) {
+		ar := AuditHeaders(w.Header(), r.TLS != nil)
+
+		uri := *r.URL
+		uri.Host = r.Host
+
+		if r.TLS != nil {
+			uri.Scheme = "https"
+		} else {
+			uri.Scheme = "http"
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(time.Now().Format("2006/01/02 15:04:05 "))
+		buf.WriteString(r.Method)
+		buf.WriteByte(' ')
+		buf.WriteString(uri.String())
+		buf.WriteString(" grade=")
+		buf.WriteString(ar.Grade)
+		buf.WriteString(" score=")
+		buf.WriteString(strconv.Itoa(ar.Score))
+		buf.WriteByte('\n')
+
+		for _, f := range ar.Findings {
+			buf.WriteString("\t")
+			buf.WriteString(f.Severity.String())
+			buf.WriteString(": ")
+			buf.WriteString(f.Header)
+			buf.WriteString(": ")
+			buf.WriteString(f.Message)
+			buf.WriteByte('\n')
+		}
+
+		buf.WriteTo(al.out)
+	})
+
+	al.h.ServeHTTP(rw, r)
+	hw.finish()
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func auditHeaderFindings(ar *AuditResult) map[string]AuditSeverity {
+	m := make(map[string]AuditSeverity)
+	for _, f := range ar.Findings {
+		if sev, ok := m[f.Header]; !ok || f.Severity > sev {
+			m[f.Header] = f.Severity
+		}
+	}
+
+	return m
+}
+
+func TestAuditHeaders(t *testing.T) {
+	h := PresetStrictHTMLV1.Header()
+	h.Set("Strict-Transport-Security", "max-age=31536000")
+
+	ar := AuditHeaders(h, true)
+	assert.Empty(t, ar.Findings)
+	assert.Equal(t, 100, ar.Score)
+	assert.Equal(t, "A+", ar.Grade)
+
+	ar = AuditHeaders(http.Header{}, true)
+	assert.Equal(t, map[string]AuditSeverity{
+		"Content-Security-Policy":   AuditError,
+		"Strict-Transport-Security": AuditError,
+		"X-Frame-Options":           AuditError,
+		"X-Content-Type-Options":    AuditError,
+		"Referrer-Policy":           AuditError,
+		"Permissions-Policy":        AuditError,
+	}, auditHeaderFindings(ar))
+	assert.Equal(t, 5, ar.Score)
+	assert.Equal(t, "F", ar.Grade)
+
+	ar = AuditHeaders(http.Header{}, false)
+	assert.Equal(t, AuditInfo, auditHeaderFindings(ar)["Strict-Transport-Security"])
+	assert.Equal(t, 25, ar.Score)
+}
+
+func TestAuditHeadersWeak(t *testing.T) {
+	h := PresetStrictHTMLV1.Header()
+	h.Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval' https:")
+	h.Set("Strict-Transport-Security", "max-age=86400")
+	h.Set("Referrer-Policy", "unsafe-url")
+	h.Set("X-Xss-Protection", "1; mode=block")
+	h.Set("Expect-Ct", "max-age=0")
+	h.Set("Public-Key-Pins", `pin-sha256="abc"; max-age=60`)
+	h.Set("Server", "nginx/1.2.3")
+
+	ar := AuditHeaders(h, true)
+
+	var messages []string
+	for _, f := range ar.Findings {
+		messages = append(messages, f.Message)
+	}
+
+	assert.Contains(t, messages, "script sources allow 'unsafe-inline'")
+	assert.Contains(t, messages, "script sources allow 'unsafe-eval'")
+	assert.Contains(t, messages, "script sources allow https:")
+	assert.Contains(t, messages, "Strict-Transport-Security max-age is less than six months")
+	assert.Equal(t, map[string]AuditSeverity{
+		"Content-Security-Policy":   AuditWarning,
+		"Strict-Transport-Security": AuditWarning,
+		"Referrer-Policy":           AuditWarning,
+		"X-Xss-Protection":          AuditWarning,
+		"Expect-Ct":                 AuditInfo,
+		"Public-Key-Pins":           AuditWarning,
+		"Server":                    AuditInfo,
+	}, auditHeaderFindings(ar))
+	assert.Equal(t, 50, ar.Score)
+	assert.Equal(t, "E", ar.Grade)
+
+	h.Set("Content-Security-Policy", "script-src 'nonce-abc' 'unsafe-inline'; frame-ancestors 'none'")
+	h.Del("X-Frame-Options")
+	assert.NotContains(t, auditHeaderFindings(AuditHeaders(h, true)), "Content-Security-Policy")
+	assert.NotContains(t, auditHeaderFindings(AuditHeaders(h, true)), "X-Frame-Options")
+}
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := AuditLog(SecurityHeadersWrap(&SecurityHeaders{
+		Preset: PresetStrictHTMLV1,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "PHP/5.6")
+		w.Write([]byte("hello"))
+	})), &buf)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/path?q=1", nil))
+
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d GET http://example.com/path\?q=1 grade=A\+ score=100
+	info: Strict-Transport-Security: response is not secure, Strict-Transport-Security not evaluated
+	info: X-Powered-By: X-Powered-By may disclose software versions
+$`, buf.String())
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+type securityHeaderOverridesKey struct{}
+
+type securityHeaderOp int
+
+const (
+	securityHeaderDisable securityHeaderOp = iota
+	securityHeaderReplace
+	securityHeaderExtend
+)
+
+type securityHeaderOverride struct {
+	op    securityHeaderOp
+	name  string
+	value string
+}
+
+// SecurityHeaderOverrides records per-request changes to
+// the headers set by SecurityHeaders. The changes are
+// applied, in the order they were made, when the response
+// headers are written.
+//
+// A nil *SecurityHeaderOverrides is valid and ignores all
+// changes.
+type SecurityHeaderOverrides struct {
+	overrides []securityHeaderOverride
+}
+
+// SecurityHeaderOverridesFromContext returns the
+// *SecurityHeaderOverrides for the request. It returns nil
+// if the request was not handled by SecurityHeaders with
+// AllowOverrides set.
+func SecurityHeaderOverridesFromContext(ctx context.Context) *SecurityHeaderOverrides {
+	ov, _ := ctx.Value(securityHeaderOverridesKey{}).(*SecurityHeaderOverrides)
+	return ov
+}
+
+func withSecurityHeaderOverrides(r *http.Request) (*http.Request, *SecurityHeaderOverrides) {
+	ov := new(SecurityHeaderOverrides)
+	return r.WithContext(context.WithValue(r.Context(), securityHeaderOverridesKey{}, ov)), ov
+}
+
+func (ov *SecurityHeaderOverrides) add(op securityHeaderOp, name, value string) {
+	if ov != nil {
+		ov.overrides = append(ov.overrides, securityHeaderOverride{op, http.CanonicalHeaderKey(name), value})
+	}
+}
+
+// Disable removes the named header from the response.
+func (ov *SecurityHeaderOverrides) Disable(name string) {
+	ov.add(securityHeaderDisable, name, "")
+}
+
+// Replace replaces the value of the named header in the
+// response.
+func (ov *SecurityHeaderOverrides) Replace(name, value string) {
+	ov.add(securityHeaderReplace, name, value)
+}
+
+// Extend appends value to the named header in the
+// response. Content-Security-Policy values are joined
+// with a semicolon, to add directives, and all other
+// values with a comma.
+func (ov *SecurityHeaderOverrides) Extend(name, value string) {
+	ov.add(securityHeaderExtend, name, value)
+}
+
+func (ov *SecurityHeaderOverrides) apply(h http.Header, r *http.Request) {
+	for _, o := range ov.overrides {
+		isCSP := o.name == "Content-Security-Policy" ||
+			o.name == "Content-Security-Policy-Report-Only"
+
+		value := o.value
+		if isCSP && CSPNonceFromContext(r.Context()) != "" {
+			value = replaceCSPNonce(r, value)
+		}
+
+		switch o.op {
+		case securityHeaderDisable:
+			delete(h, o.name)
+		case securityHeaderReplace:
+			h[o.name] = []string{value}
+		case securityHeaderExtend:
+			sep := ", "
+			if isCSP {
+				sep = "; "
+			}
+
+			if prev := h.Get(o.name); prev != "" {
+				value = prev + sep + value
+			}
+
+			h[o.name] = []string{value}
+		}
+	}
+}
+
+// DisableSecurityHeader wraps a http.Handler and removes
+// the named header, set by an outer SecurityHeaders with
+// AllowOverrides set, from the response.
+func DisableSecurityHeader(h http.Handler, name string) Handler {
+	return &securityHeaderOverrider{h, securityHeaderOverride{securityHeaderDisable, name, ""}}
+}
+
+// DisableSecurityHeaderWrap returns a Middleware that
+// calls DisableSecurityHeader.
+func DisableSecurityHeaderWrap(name string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return DisableSecurityHeader(h, name)
+	}
+}
+
+// ReplaceSecurityHeader wraps a http.Handler and replaces
+// the value of the named header, set by an outer
+// SecurityHeaders with AllowOverrides set, in the
+// response.
+func ReplaceSecurityHeader(h http.Handler, name, value string) Handler {
+	return &securityHeaderOverrider{h, securityHeaderOverride{securityHeaderReplace, name, value}}
+}
+
+// ReplaceSecurityHeaderWrap returns a Middleware that
+// calls ReplaceSecurityHeader.
+func ReplaceSecurityHeaderWrap(name, value string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return ReplaceSecurityHeader(h, name, value)
+	}
+}
+
+// ExtendSecurityHeader wraps a http.Handler and appends
+// value to the named header, set by an outer
+// SecurityHeaders with AllowOverrides set, in the
+// response.
+func ExtendSecurityHeader(h http.Handler, name, value string) Handler {
+	return &securityHeaderOverrider{h, securityHeaderOverride{securityHeaderExtend, name, value}}
+}
+
+// ExtendSecurityHeaderWrap returns a Middleware that
+// calls ExtendSecurityHeader.
+func ExtendSecurityHeaderWrap(name, value string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return ExtendSecurityHeader(h, name, value)
+	}
+}
+
+type securityHeaderOverrider struct {
+	h http.Handler
+	o securityHeaderOverride
+}
+
+func (so *securityHeaderOverrider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	SecurityHeaderOverridesFromContext(r.Context()).add(so.o.op, so.o.name, so.o.value)
+
+	so.h.ServeHTTP(w, r)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaderOverrides(t *testing.T) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ov := SecurityHeaderOverridesFromContext(r.Context())
+		ov.Replace("x-frame-options", "DENY")
+		ov.Extend("Content-Security-Policy", "frame-ancestors https://partner.example")
+
+		w.WriteHeader(http.StatusOK)
+	})
+	h = DisableSecurityHeader(h, "Referrer-Policy")
+	h = ReplaceSecurityHeaderWrap("X-Frame-Options", "ALLOW-FROM https://partner.example")(h)
+	h = ExtendSecurityHeaderWrap("Permissions-Policy", "camera=()")(h)
+	h = SecurityHeadersWrap(&SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'self'",
+		AllowOverrides:        true,
+	})(h)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.Header{
+		"X-Frame-Options":         {"DENY"},
+		"X-Xss-Protection":        {"1; mode=block"},
+		"X-Content-Type-Options":  {"nosniff"},
+		"Content-Security-Policy": {"default-src 'self'; frame-ancestors https://partner.example"},
+		"Permissions-Policy":      {"camera=()"},
+	}, w.Result().Header)
+}
+
+func TestSecurityHeaderOverridesByContentType(t *testing.T) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>widget</p>"))
+	})
+	h = DisableSecurityHeaderWrap("X-Frame-Options")(h)
+	h = ReplaceSecurityHeaderWrap("Content-Security-Policy", "script-src 'nonce-{nonce}'")(h)
+	h = SecurityHeadersWrap(&SecurityHeaders{
+		Preset:         PresetStrictHTMLV1,
+		ByContentType:  true,
+		CSPNonce:       true,
+		AllowOverrides: true,
+	})(h)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.NotContains(t, w.Header(), "X-Frame-Options")
+	assert.Regexp(t, `^script-src 'nonce-[A-Za-z0-9_-]{24}'$`, w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "same-origin", w.Header().Get("Cross-Origin-Opener-Policy"))
+}
+
+func TestSecurityHeaderOverridesDisallowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	h := SecurityHeadersWrap(nil)(DisableSecurityHeader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, SecurityHeaderOverridesFromContext(r.Context()))
+		assert.Equal(t, http.ResponseWriter(rec), w, "ResponseWriter wrapped without ByContentType or AllowOverrides")
+	}), "X-Frame-Options"))
+
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "SAMEORIGIN", rec.Header().Get("X-Frame-Options"))
+}
+
+func TestSecurityHeaderOverridesNil(t *testing.T) {
+	ov := SecurityHeaderOverridesFromContext(context.Background())
+	assert.Nil(t, ov)
+
+	assert.NotPanics(t, func() {
+		ov.Disable("X-Frame-Options")
+		ov.Replace("X-Frame-Options", "DENY")
+		ov.Extend("X-Frame-Options", "DENY")
+	})
+
+	h := DisableSecurityHeader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	}), "X-Frame-Options")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, 999, w.Code, "http.Handler not invoked")
+}
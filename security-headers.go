@@ -5,12 +5,18 @@
 
 package handlers
 
-import "net/http"
+import (
+	"fmt"
+	"math/rand"
+	"mime"
+	"net/http"
+	"strings"
+)
 
 // SecurityHeaders sets several recommended security related
 // headers to sane defaults.
 //
-// It sets:
+// Unless Preset is set, it sets:
 //  - X-Frame-Options: SAMEORIGIN,
 //  - X-XSS-Protection: 1; mode=block,
 //  - X-Content-Type-Options: nosniff, and
@@ -19,9 +25,29 @@ import "net/http"
 // It also optionally sets the Content-Security-Policy,
 // Strict-Transport-Security and Expect-CT to user
 // specified values.
+//
+// If AllowOverrides is true, individual headers may be
+// disabled, replaced or extended for a single request, by
+// the Handler or by nested middleware, with
+// SecurityHeaderOverridesFromContext,
+// DisableSecurityHeader, ReplaceSecurityHeader or
+// ExtendSecurityHeader. These changes are applied when
+// the response headers are written.
 type SecurityHeaders struct {
 	Handler http.Handler
 
+	// Optionally specifies a versioned preset that
+	// replaces the default headers listed above with
+	// the full recommended set of headers for that
+	// preset. Any other field that is set overrides
+	// the corresponding header from the preset.
+	//
+	// The defaults used when Preset is empty are kept
+	// for compatibility. In particular, current
+	// guidance is that X-XSS-Protection should be 0,
+	// which every preset uses.
+	Preset SecurityPreset
+
 	// The value of the Content-Security-Policy
 	// header to set.
 	//
@@ -39,6 +65,54 @@ type SecurityHeaders struct {
 	// for more information.
 	ContentSecurityPolicy string
 
+	// A typed Content-Security-Policy to set. If
+	// non-nil, it takes precedence over
+	// ContentSecurityPolicy.
+	//
+	// The policy should be checked with
+	// (*CSP).Validate before use, as browsers
+	// silently ignore malformed directives.
+	// SecurityHeadersWrap will panic if the policy
+	// is invalid.
+	CSP *CSP
+
+	// The value of the
+	// Content-Security-Policy-Report-Only header to
+	// set.
+	//
+	// A report-only policy is not enforced, but
+	// violations are reported to the policy's
+	// report-uri or report-to endpoint. It is
+	// independent of ContentSecurityPolicy, so a
+	// stricter policy can be trialled alongside the
+	// enforced one.
+	ContentSecurityPolicyReportOnly string
+
+	// A typed Content-Security-Policy-Report-Only to
+	// set. If non-nil, it takes precedence over
+	// ContentSecurityPolicyReportOnly.
+	CSPReportOnly *CSP
+
+	// If non-zero, the report-only policy is only set
+	// on this fraction of requests, between 0 and 1,
+	// chosen at random. It allows the breakage of a
+	// stricter policy to be measured on a sample of
+	// traffic. If zero, it is set on every request.
+	CSPReportOnlySampleRate float64
+
+	// If true, a cryptographically random nonce is
+	// generated for each request. Every occurrence
+	// of CSPNoncePlaceholder in the
+	// Content-Security-Policy and the
+	// Content-Security-Policy-Report-Only is replaced
+	// with it, and it is made available to the
+	// Handler via CSPNonceFromContext.
+	//
+	// The nonce can be stamped on script and style
+	// tags with the html/template functions from
+	// CSPNonceFuncs, or with ServeNonceTemplate.
+	CSPNonce bool
+
 	// The value of the Strict-Transport-Security
 	// header to set.
 	//
@@ -68,8 +142,17 @@ type SecurityHeaders struct {
 	// for more information.
 	StrictTransportSecurity string
 
+	// A typed Strict-Transport-Security policy to
+	// set. If non-nil, it takes precedence over
+	// StrictTransportSecurity and is only set on
+	// secure requests.
+	HSTS *HSTS
+
 	// The value of the Expect-CT header to set.
 	//
+	// Expect-CT is deprecated and ignored by current
+	// browsers. It is not set by any Preset.
+	//
 	// It takes a max-age directive, with time in
 	// seconds, which indicate how long browsers
 	// should cache the policy.
@@ -120,38 +203,511 @@ type SecurityHeaders struct {
 	//   https://scotthelme.co.uk/a-new-security-header-feature-policy/
 	// for more information.
 	FeaturePolicy string
+
+	// A typed Permissions-Policy to set.
+	//
+	// Permissions-Policy replaces Feature-Policy in
+	// modern browsers. SecurityHeadersWrap will
+	// panic if the policy is invalid.
+	//
+	// See https://www.w3.org/TR/permissions-policy/
+	// for more information.
+	PermissionsPolicy PermissionsPolicy
+
+	// If true and PermissionsPolicy is nil,
+	// FeaturePolicy is translated with
+	// ParseFeaturePolicy and also sent as a
+	// Permissions-Policy header. SecurityHeadersWrap
+	// will panic if FeaturePolicy cannot be
+	// translated.
+	TranslateFeaturePolicy bool
+
+	// The value of the Cross-Origin-Opener-Policy
+	// header to set.
+	//
+	// It should be one of same-origin,
+	// same-origin-allow-popups, noopener-allow-popups
+	// or unsafe-none, optionally followed by a
+	// report-to parameter, for example
+	//  same-origin; report-to="coop"
+	CrossOriginOpenerPolicy string
+
+	// The value of the
+	// Cross-Origin-Opener-Policy-Report-Only header to
+	// set. It takes the same values as
+	// CrossOriginOpenerPolicy.
+	CrossOriginOpenerPolicyReportOnly string
+
+	// The value of the Cross-Origin-Embedder-Policy
+	// header to set.
+	//
+	// It should be one of require-corp,
+	// credentialless or unsafe-none, optionally
+	// followed by a report-to parameter.
+	CrossOriginEmbedderPolicy string
+
+	// The value of the
+	// Cross-Origin-Embedder-Policy-Report-Only header
+	// to set. It takes the same values as
+	// CrossOriginEmbedderPolicy.
+	CrossOriginEmbedderPolicyReportOnly string
+
+	// The value of the Cross-Origin-Resource-Policy
+	// header to set.
+	//
+	// It should be one of same-site, same-origin or
+	// cross-origin.
+	CrossOriginResourcePolicy string
+
+	// If true, the response is made cross-origin
+	// isolated, which is required to use
+	// SharedArrayBuffer and similar features.
+	//
+	// It sets Cross-Origin-Opener-Policy to
+	// same-origin, Cross-Origin-Embedder-Policy to
+	// require-corp and Cross-Origin-Resource-Policy to
+	// same-origin, unless they have been set to a
+	// value that is compatible with cross-origin
+	// isolation. Validate will return an error if they
+	// have been set to an incompatible value.
+	//
+	// See https://web.dev/coop-coep/ for more
+	// information.
+	CrossOriginIsolated bool
+
+	// If true, the headers are not set until the
+	// Handler writes the response headers, and are
+	// then selected based on the Content-Type of the
+	// response.
+	//
+	// HTML documents, which are responses with a
+	// Content-Type of text/html,
+	// application/xhtml+xml or image/svg+xml, or
+	// without a Content-Type, receive all the headers
+	// configured here. Other responses receive the
+	// headers configured by NonDocument.
+	ByContentType bool
+
+	// The headers to set for responses that are not
+	// HTML documents when ByContentType is true. The
+	// Handler field is ignored.
+	//
+	// If NonDocument is nil, those responses only
+	// receive X-Content-Type-Options: nosniff and the
+	// Strict-Transport-Security, Report-To, NEL and
+	// Cross-Origin-Resource-Policy headers configured
+	// here.
+	NonDocument *SecurityHeaders
+
+	// Optionally specifies the headers to set for
+	// specific response media types when
+	// ByContentType is true. The keys are lowercase
+	// media types, like application/json, or
+	// wildcards, like image/*. The Handler field of
+	// each value is ignored.
+	//
+	// ContentTypes takes priority over both
+	// NonDocument and the headers configured here.
+	ContentTypes map[string]*SecurityHeaders
+
+	// If true, the headers may be changed for a
+	// single request with
+	// SecurityHeaderOverridesFromContext and the
+	// *SecurityHeader middleware. Otherwise those
+	// changes are ignored.
+	AllowOverrides bool
+
+	// The header values derived from the typed
+	// policies, computed once by SecurityHeadersWrap.
+	values *securityHeaderValues
+}
+
+type securityHeaderValues struct {
+	csp, cspReportOnly string
+	permissionsPolicy  string
+}
+
+var (
+	coopValues = map[string]bool{
+		"same-origin":              true,
+		"same-origin-allow-popups": true,
+		"noopener-allow-popups":    true,
+		"unsafe-none":              true,
+	}
+	coepValues = map[string]bool{
+		"require-corp":   true,
+		"credentialless": true,
+		"unsafe-none":    true,
+	}
+	corpValues = map[string]bool{
+		"same-site":    true,
+		"same-origin":  true,
+		"cross-origin": true,
+	}
+)
+
+// crossOriginPolicy returns the value of a
+// Cross-Origin-*-Policy header without any
+// parameters.
+func crossOriginPolicy(v string) string {
+	if idx := strings.IndexByte(v, ';'); idx >= 0 {
+		v = v[:idx]
+	}
+
+	return strings.TrimSpace(v)
+}
+
+func (sh *SecurityHeaders) crossOriginPolicies() (coop, coep, corp string) {
+	coop = sh.CrossOriginOpenerPolicy
+	coep = sh.CrossOriginEmbedderPolicy
+	corp = sh.CrossOriginResourcePolicy
+
+	if sh.CrossOriginIsolated {
+		if coop == "" {
+			coop = "same-origin"
+		}
+
+		if coep == "" {
+			coep = "require-corp"
+		}
+
+		if corp == "" {
+			corp = "same-origin"
+		}
+	}
+
+	return
+}
+
+// Validate returns an error if the preset is unknown, if
+// the typed policies or the cross-origin headers are
+// invalid, or if the cross-origin headers are
+// incompatible with CrossOriginIsolated.
+//
+// SecurityHeadersWrap will panic if Validate returns an
+// error.
+func (sh *SecurityHeaders) Validate() error {
+	if sh.Preset != "" && !sh.Preset.Valid() {
+		return fmt.Errorf("handlers: unknown security preset %q", sh.Preset)
+	}
+
+	if sh.CSP != nil {
+		if err := sh.CSP.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := sh.PermissionsPolicy.Validate(); err != nil {
+		return err
+	}
+
+	if sh.CSPReportOnly != nil {
+		if err := sh.CSPReportOnly.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if sh.CSPReportOnlySampleRate < 0 || sh.CSPReportOnlySampleRate > 1 {
+		return fmt.Errorf("handlers: CSPReportOnlySampleRate must be between 0 and 1")
+	}
+
+	if sh.HSTS != nil {
+		if err := sh.HSTS.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if sh.PermissionsPolicy == nil && sh.TranslateFeaturePolicy {
+		if _, err := ParseFeaturePolicy(sh.FeaturePolicy); err != nil {
+			return err
+		}
+	}
+
+	for _, hdr := range []struct {
+		name, value string
+		valid       map[string]bool
+	}{
+		{"Cross-Origin-Opener-Policy", sh.CrossOriginOpenerPolicy, coopValues},
+		{"Cross-Origin-Opener-Policy-Report-Only", sh.CrossOriginOpenerPolicyReportOnly, coopValues},
+		{"Cross-Origin-Embedder-Policy", sh.CrossOriginEmbedderPolicy, coepValues},
+		{"Cross-Origin-Embedder-Policy-Report-Only", sh.CrossOriginEmbedderPolicyReportOnly, coepValues},
+		{"Cross-Origin-Resource-Policy", sh.CrossOriginResourcePolicy, corpValues},
+	} {
+		if hdr.value != "" && !hdr.valid[crossOriginPolicy(hdr.value)] {
+			return fmt.Errorf("handlers: invalid %s value %q", hdr.name, hdr.value)
+		}
+	}
+
+	if sh.NonDocument != nil {
+		if err := sh.NonDocument.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range sh.ContentTypes {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if !sh.CrossOriginIsolated {
+		return nil
+	}
+
+	coop, coep, _ := sh.crossOriginPolicies()
+
+	if crossOriginPolicy(coop) != "same-origin" {
+		return fmt.Errorf("handlers: Cross-Origin-Opener-Policy must be same-origin for cross-origin isolation, not %q", coop)
+	}
+
+	switch crossOriginPolicy(coep) {
+	case "require-corp", "credentialless":
+	default:
+		return fmt.Errorf("handlers: Cross-Origin-Embedder-Policy must be require-corp or credentialless for cross-origin isolation, not %q", coep)
+	}
+
+	return nil
 }
 
 // SecurityHeadersWrap returns a Middleware that produces a
 // *SecurityHeaders handler.
+//
+// The typed policies are converted to header values once,
+// so later changes to them are not reflected in the
+// handlers it produces.
 func SecurityHeadersWrap(c *SecurityHeaders) Middleware {
+	if c != nil {
+		if err := c.Validate(); err != nil {
+			panic(err)
+		}
+	} else {
+		c = new(SecurityHeaders)
+	}
+
+	c = c.compile()
+
 	return func(h http.Handler) http.Handler {
 		sh := new(SecurityHeaders)
+		*sh = *c
+		sh.Handler = h
+		return sh
+	}
+}
+
+// compile returns a copy of sh, and of NonDocument and
+// ContentTypes, with the header values precomputed.
+func (sh *SecurityHeaders) compile() *SecurityHeaders {
+	c := *sh
+	c.values = sh.computeValues()
+
+	if sh.NonDocument != nil {
+		c.NonDocument = sh.NonDocument.compile()
+	}
 
-		if c != nil {
-			*sh = *c
+	if sh.ContentTypes != nil {
+		c.ContentTypes = make(map[string]*SecurityHeaders, len(sh.ContentTypes))
+
+		for mediaType, p := range sh.ContentTypes {
+			c.ContentTypes[mediaType] = p.compile()
 		}
+	}
 
-		sh.Handler = h
-		return sh
+	return &c
+}
+
+func (sh *SecurityHeaders) computeValues() *securityHeaderValues {
+	v := &securityHeaderValues{
+		csp:           sh.ContentSecurityPolicy,
+		cspReportOnly: sh.ContentSecurityPolicyReportOnly,
 	}
+
+	if sh.CSP != nil {
+		v.csp = sh.CSP.String()
+	}
+
+	if sh.CSPReportOnly != nil {
+		v.cspReportOnly = sh.CSPReportOnly.String()
+	}
+
+	if sh.PermissionsPolicy != nil {
+		v.permissionsPolicy = sh.PermissionsPolicy.String()
+	} else if sh.TranslateFeaturePolicy && sh.FeaturePolicy != "" {
+		// Validate reports an error if FeaturePolicy
+		// cannot be translated.
+		if pp, err := ParseFeaturePolicy(sh.FeaturePolicy); err == nil {
+			v.permissionsPolicy = pp.String()
+		}
+	}
+
+	return v
+}
+
+// headerValues returns the precomputed header values, or
+// computes them if sh was not produced by
+// SecurityHeadersWrap.
+func (sh *SecurityHeaders) headerValues() *securityHeaderValues {
+	if sh.values != nil {
+		return sh.values
+	}
+
+	return sh.computeValues()
 }
 
 // ServeHTTP implements http.Handler.
 func (sh *SecurityHeaders) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h := w.Header()
-	h.Set("X-Frame-Options", "SAMEORIGIN")
-	h.Set("X-Xss-Protection", "1; mode=block")
-	h.Set("X-Content-Type-Options", "nosniff")
-	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	if sh.needsCSPNonce() {
+		r = withCSPNonce(r)
+	}
+
+	if !sh.ByContentType {
+		sh.setHeaders(w.Header(), r)
+
+		if !sh.AllowOverrides {
+			sh.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	var ov *SecurityHeaderOverrides
+	if sh.AllowOverrides {
+		r, ov = withSecurityHeaderOverrides(r)
+	}
+
+	rw, hw := newHookResponseWriter(w, func(code int, sniff []byte) {
+		h := w.Header()
+
+		if sh.ByContentType {
+			ctype := h.Get("Content-Type")
+			if _, hasType := h["Content-Type"]; !hasType && sniff != nil {
+				ctype = http.DetectContentType(sniff)
+			}
+
+			if p := sh.contentTypePolicy(ctype); p != nil {
+				p.setHeaders(h, r)
+			} else {
+				sh.setNonDocumentHeaders(h, r)
+			}
+		}
+
+		if ov != nil {
+			ov.apply(h, r)
+		}
+	})
+
+	sh.Handler.ServeHTTP(rw, r)
+	hw.finish()
+}
+
+func (sh *SecurityHeaders) needsCSPNonce() bool {
+	if sh.CSPNonce || (sh.NonDocument != nil && sh.NonDocument.CSPNonce) {
+		return true
+	}
+
+	for _, p := range sh.ContentTypes {
+		if p.CSPNonce {
+			return true
+		}
+	}
+
+	return false
+}
+
+// htmlDocumentTypes are the media types that are treated
+// as documents by ByContentType.
+var htmlDocumentTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+}
+
+// contentTypePolicy returns the *SecurityHeaders to use for
+// a response with the given Content-Type, or nil if the
+// default non-document headers should be used.
+func (sh *SecurityHeaders) contentTypePolicy(ctype string) *SecurityHeaders {
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		mediaType = ""
+	}
+
+	if p, ok := sh.ContentTypes[mediaType]; ok {
+		return p
+	}
 
-	if sh.ContentSecurityPolicy != "" {
-		h.Set("Content-Security-Policy", sh.ContentSecurityPolicy)
+	if idx := strings.IndexByte(mediaType, '/'); idx >= 0 {
+		if p, ok := sh.ContentTypes[mediaType[:idx]+"/*"]; ok {
+			return p
+		}
+	}
+
+	if mediaType == "" || htmlDocumentTypes[mediaType] {
+		return sh
 	}
 
-	if sh.StrictTransportSecurity != "" {
+	return sh.NonDocument
+}
+
+func (sh *SecurityHeaders) setHSTS(h http.Header, r *http.Request) {
+	switch {
+	case sh.HSTS != nil:
+		if sh.HSTS.secure(r) {
+			// The stage in effect depends on the
+			// time, so it is chosen per request.
+			h.Set("Strict-Transport-Security", sh.HSTS.String())
+		}
+	case sh.StrictTransportSecurity != "":
 		h.Set("Strict-Transport-Security", sh.StrictTransportSecurity)
 	}
+}
+
+func (sh *SecurityHeaders) setNonDocumentHeaders(h http.Header, r *http.Request) {
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	sh.setHSTS(h, r)
+
+	if sh.ReportTo != "" {
+		h.Set("Report-To", sh.ReportTo)
+	}
+
+	if sh.NEL != "" {
+		h.Set("Nel", sh.NEL)
+	}
+
+	if _, _, corp := sh.crossOriginPolicies(); corp != "" {
+		h.Set("Cross-Origin-Resource-Policy", corp)
+	}
+}
+
+func (sh *SecurityHeaders) setHeaders(h http.Header, r *http.Request) {
+	defaults := legacySecurityHeaders
+	if sh.Preset != "" {
+		defaults = securityPresets[sh.Preset]
+	}
+
+	for k, v := range defaults {
+		h[k] = []string{v}
+	}
+
+	values := sh.headerValues()
+
+	csp := values.csp
+	if sh.CSPNonce {
+		csp = replaceCSPNonce(r, csp)
+	}
+
+	if csp != "" {
+		h.Set("Content-Security-Policy", csp)
+	}
+
+	cspro := values.cspReportOnly
+	if sh.CSPNonce {
+		cspro = replaceCSPNonce(r, cspro)
+	}
+
+	if cspro != "" && (sh.CSPReportOnlySampleRate == 0 || rand.Float64() < sh.CSPReportOnlySampleRate) {
+		h.Set("Content-Security-Policy-Report-Only", cspro)
+	}
+
+	sh.setHSTS(h, r)
 
 	if sh.ExpectCT != "" {
 		h.Set("Expect-Ct", sh.ExpectCT)
@@ -169,5 +725,29 @@ func (sh *SecurityHeaders) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.Set("Feature-Policy", sh.FeaturePolicy)
 	}
 
-	sh.Handler.ServeHTTP(w, r)
+	if values.permissionsPolicy != "" {
+		h.Set("Permissions-Policy", values.permissionsPolicy)
+	}
+
+	coop, coep, corp := sh.crossOriginPolicies()
+
+	if coop != "" {
+		h.Set("Cross-Origin-Opener-Policy", coop)
+	}
+
+	if sh.CrossOriginOpenerPolicyReportOnly != "" {
+		h.Set("Cross-Origin-Opener-Policy-Report-Only", sh.CrossOriginOpenerPolicyReportOnly)
+	}
+
+	if coep != "" {
+		h.Set("Cross-Origin-Embedder-Policy", coep)
+	}
+
+	if sh.CrossOriginEmbedderPolicyReportOnly != "" {
+		h.Set("Cross-Origin-Embedder-Policy-Report-Only", sh.CrossOriginEmbedderPolicyReportOnly)
+	}
+
+	if corp != "" {
+		h.Set("Cross-Origin-Resource-Policy", corp)
+	}
 }
@@ -6,11 +6,14 @@
 package handlers
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSecurityHeaders(t *testing.T) {
@@ -104,3 +107,291 @@ func TestSecurityHeaders(t *testing.T) {
 		"Expect-Ct":                 {"leave"},
 	}, w.Result().Header)
 }
+
+func TestSecurityHeadersCrossOrigin(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+
+		CrossOriginOpenerPolicy:             "same-origin-allow-popups",
+		CrossOriginOpenerPolicyReportOnly:   `same-origin; report-to="coop"`,
+		CrossOriginEmbedderPolicyReportOnly: "require-corp",
+		CrossOriginResourcePolicy:           "same-site",
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, "same-origin-allow-popups", w.Header().Get("Cross-Origin-Opener-Policy"))
+	assert.Equal(t, `same-origin; report-to="coop"`, w.Header().Get("Cross-Origin-Opener-Policy-Report-Only"))
+	assert.Empty(t, w.Header().Get("Cross-Origin-Embedder-Policy"))
+	assert.Equal(t, "require-corp", w.Header().Get("Cross-Origin-Embedder-Policy-Report-Only"))
+	assert.Equal(t, "same-site", w.Header().Get("Cross-Origin-Resource-Policy"))
+
+	w = httptest.NewRecorder()
+	SecurityHeadersWrap(&SecurityHeaders{
+		CrossOriginIsolated: true,
+	})(h).ServeHTTP(w, r)
+
+	assert.Equal(t, "same-origin", w.Header().Get("Cross-Origin-Opener-Policy"))
+	assert.Equal(t, "require-corp", w.Header().Get("Cross-Origin-Embedder-Policy"))
+	assert.Equal(t, "same-origin", w.Header().Get("Cross-Origin-Resource-Policy"))
+
+	w = httptest.NewRecorder()
+	SecurityHeadersWrap(&SecurityHeaders{
+		CrossOriginIsolated: true,
+
+		CrossOriginEmbedderPolicy: `credentialless; report-to="coep"`,
+		CrossOriginResourcePolicy: "cross-origin",
+	})(h).ServeHTTP(w, r)
+
+	assert.Equal(t, "same-origin", w.Header().Get("Cross-Origin-Opener-Policy"))
+	assert.Equal(t, `credentialless; report-to="coep"`, w.Header().Get("Cross-Origin-Embedder-Policy"))
+	assert.Equal(t, "cross-origin", w.Header().Get("Cross-Origin-Resource-Policy"))
+}
+
+func TestSecurityHeadersValidateCrossOrigin(t *testing.T) {
+	for _, sh := range []*SecurityHeaders{
+		{CrossOriginIsolated: true},
+		{CrossOriginIsolated: true, CrossOriginOpenerPolicy: `same-origin; report-to="a"`},
+		{CrossOriginIsolated: true, CrossOriginEmbedderPolicy: "credentialless"},
+		{CrossOriginIsolated: true, CrossOriginOpenerPolicyReportOnly: "unsafe-none"},
+		{CrossOriginOpenerPolicy: "unsafe-none", CrossOriginEmbedderPolicy: "unsafe-none"},
+		{CrossOriginResourcePolicy: "same-origin"},
+	} {
+		assert.NoError(t, sh.Validate(), "%+v", sh)
+	}
+
+	for _, sh := range []*SecurityHeaders{
+		{CrossOriginOpenerPolicy: "same-orgin"},
+		{CrossOriginEmbedderPolicyReportOnly: "require-cors"},
+		{CrossOriginResourcePolicy: "same-origin-allow-popups"},
+		{CrossOriginIsolated: true, CrossOriginOpenerPolicy: "same-origin-allow-popups"},
+		{CrossOriginIsolated: true, CrossOriginOpenerPolicy: "unsafe-none"},
+		{CrossOriginIsolated: true, CrossOriginEmbedderPolicy: "unsafe-none"},
+	} {
+		assert.Error(t, sh.Validate(), "%+v", sh)
+	}
+
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			CrossOriginIsolated:     true,
+			CrossOriginOpenerPolicy: "unsafe-none",
+		})
+	})
+}
+
+func TestSecurityHeadersPreset(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+		Preset:  PresetLegacyCompatibleV1,
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, http.Header{
+		"X-Frame-Options":        {"SAMEORIGIN"},
+		"X-Xss-Protection":       {"0"},
+		"X-Content-Type-Options": {"nosniff"},
+		"Referrer-Policy":        {"strict-origin-when-cross-origin"},
+	}, w.Result().Header)
+
+	w = httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+		Preset:  PresetAPIV1,
+
+		ContentSecurityPolicy: "default-src 'none'",
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, http.Header{
+		"X-Frame-Options":              {"DENY"},
+		"X-Xss-Protection":             {"0"},
+		"X-Content-Type-Options":       {"nosniff"},
+		"Referrer-Policy":              {"no-referrer"},
+		"Content-Security-Policy":      {"default-src 'none'"},
+		"Cross-Origin-Resource-Policy": {"same-origin"},
+	}, w.Result().Header)
+
+	w = httptest.NewRecorder()
+	(&SecurityHeaders{
+		Handler: h,
+		Preset:  PresetStrictHTMLV1,
+	}).ServeHTTP(w, r)
+
+	assert.Equal(t, PresetStrictHTMLV1.Header(), w.Result().Header)
+}
+
+func TestSecurityPresets(t *testing.T) {
+	for _, p := range []SecurityPreset{
+		PresetStrictHTMLV1,
+		PresetAPIV1,
+		PresetLegacyCompatibleV1,
+	} {
+		require.True(t, p.Valid(), "%s", p)
+
+		h := p.Header()
+		assert.Equal(t, "0", h.Get("X-Xss-Protection"), "%s", p)
+		assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"), "%s", p)
+		assert.NotContains(t, h, "Expect-Ct", "%s", p)
+
+		if csp := h.Get("Content-Security-Policy"); csp != "" {
+			_, err := ParseCSP(csp)
+			assert.NoError(t, err, "%s", p)
+		}
+
+		h.Set("X-Frame-Options", "modified")
+		assert.NotEqual(t, "modified", p.Header().Get("X-Frame-Options"), "%s", p)
+
+		assert.NoError(t, (&SecurityHeaders{Preset: p}).Validate())
+	}
+
+	assert.False(t, SecurityPreset("strict-html/v0").Valid())
+	assert.Nil(t, SecurityPreset("strict-html/v0").Header())
+	assert.Error(t, (&SecurityHeaders{Preset: "strict-html/v0"}).Validate())
+}
+
+func TestSecurityHeadersByContentType(t *testing.T) {
+	sh := &SecurityHeaders{
+		ContentSecurityPolicy:   "default-src 'self'",
+		StrictTransportSecurity: "max-age=31536000",
+
+		ByContentType: true,
+		ContentTypes: map[string]*SecurityHeaders{
+			"image/*": {
+				Preset: PresetAPIV1,
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		ctype, body string
+		csp, xfo    string
+	}{
+		{"text/html; charset=utf-8", "", "default-src 'self'", "SAMEORIGIN"},
+		{"application/xhtml+xml", "", "default-src 'self'", "SAMEORIGIN"},
+		{"", "<!doctype html><p>test</p>", "default-src 'self'", "SAMEORIGIN"},
+		{"", "", "default-src 'self'", "SAMEORIGIN"},
+		{"application/json", "{}", "", ""},
+		{"", "\x89PNG\x0D\x0A\x1A\x0A", "default-src 'none'; frame-ancestors 'none'; sandbox", "DENY"},
+		{"image/jpeg", "", "default-src 'none'; frame-ancestors 'none'; sandbox", "DENY"},
+	} {
+		h := SecurityHeadersWrap(sh)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.ctype != "" {
+				w.Header().Set("Content-Type", tc.ctype)
+			}
+
+			if tc.body != "" {
+				io.WriteString(w, tc.body)
+			}
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.csp, w.Header().Get("Content-Security-Policy"), "%q %q", tc.ctype, tc.body)
+		assert.Equal(t, tc.xfo, w.Header().Get("X-Frame-Options"), "%q %q", tc.ctype, tc.body)
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), "%q %q", tc.ctype, tc.body)
+	}
+
+	sh.NonDocument = &SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'none'",
+	}
+
+	h := SecurityHeadersWrap(sh)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "default-src 'none'", w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+}
+
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int
+}
+
+func (w *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom++
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func TestSecurityHeadersResponseWriter(t *testing.T) {
+	body := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{0}, 1024)...)
+
+	for _, byContentType := range []bool{false, true} {
+		w := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+		h := SecurityHeadersWrap(&SecurityHeaders{
+			ByContentType:  byContentType,
+			AllowOverrides: !byContentType,
+		})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			u, ok := rw.(interface{ Unwrap() http.ResponseWriter })
+			if assert.True(t, ok, "Unwrap not implemented") {
+				assert.Equal(t, http.ResponseWriter(w), u.Unwrap())
+			}
+
+			n, err := io.Copy(rw, struct{ io.Reader }{bytes.NewReader(body)})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(body)), n)
+		}))
+
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, 1, w.readFrom, "ReadFrom not forwarded")
+		assert.Equal(t, body, w.Body.Bytes())
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	}
+
+	assert.Equal(t, "DENY", func() string {
+		w := httptest.NewRecorder()
+
+		SecurityHeadersWrap(&SecurityHeaders{
+			ByContentType: true,
+			ContentTypes: map[string]*SecurityHeaders{
+				"image/*": {Preset: PresetAPIV1},
+			},
+		})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			io.Copy(rw, struct{ io.Reader }{bytes.NewReader(body)})
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		return w.Header().Get("X-Frame-Options")
+	}(), "ReadFrom did not sniff the body")
+}
+
+func TestSecurityHeadersByContentTypeDefers(t *testing.T) {
+	h := SecurityHeadersWrap(&SecurityHeaders{
+		ByContentType: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, w.Header().Get("X-Frame-Options"), "headers set before WriteHeader")
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+
+		assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"), "headers not set at WriteHeader")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+
+	assert.Panics(t, func() {
+		SecurityHeadersWrap(&SecurityHeaders{
+			ByContentType: true,
+			ContentTypes: map[string]*SecurityHeaders{
+				"application/json": {Preset: "unknown"},
+			},
+		})
+	})
+}
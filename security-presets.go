@@ -0,0 +1,115 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import "net/http"
+
+// SecurityPreset is a named, versioned set of security
+// headers for use with SecurityHeaders.
+//
+// The headers of a given preset version never change.
+// When recommendations change, a new version of the
+// preset is added instead, so upgrading this package
+// will only change the headers sent if a newer preset
+// version is explicitly chosen.
+type SecurityPreset string
+
+// The available security presets.
+const (
+	// PresetStrictHTMLV1 is intended for HTML
+	// documents. It sets:
+	//  - X-Frame-Options: DENY,
+	//  - X-XSS-Protection: 0,
+	//  - X-Content-Type-Options: nosniff,
+	//  - Referrer-Policy: strict-origin-when-cross-origin,
+	//  - Content-Security-Policy: default-src 'self';
+	//    base-uri 'self'; form-action 'self';
+	//    frame-ancestors 'none'; object-src 'none';
+	//    upgrade-insecure-requests,
+	//  - Cross-Origin-Opener-Policy: same-origin,
+	//  - Cross-Origin-Resource-Policy: same-origin, and
+	//  - Permissions-Policy: camera=(), geolocation=(),
+	//    microphone=(), payment=(), usb=().
+	PresetStrictHTMLV1 SecurityPreset = "strict-html/v1"
+
+	// PresetAPIV1 is intended for JSON and other
+	// non-document API responses. It sets:
+	//  - X-Frame-Options: DENY,
+	//  - X-XSS-Protection: 0,
+	//  - X-Content-Type-Options: nosniff,
+	//  - Referrer-Policy: no-referrer,
+	//  - Content-Security-Policy: default-src 'none';
+	//    frame-ancestors 'none'; sandbox, and
+	//  - Cross-Origin-Resource-Policy: same-origin.
+	PresetAPIV1 SecurityPreset = "api/v1"
+
+	// PresetLegacyCompatibleV1 is intended for sites
+	// that cannot yet adopt a Content-Security-Policy
+	// or cross-origin isolation. It sets:
+	//  - X-Frame-Options: SAMEORIGIN,
+	//  - X-XSS-Protection: 0,
+	//  - X-Content-Type-Options: nosniff, and
+	//  - Referrer-Policy: strict-origin-when-cross-origin.
+	PresetLegacyCompatibleV1 SecurityPreset = "legacy-compatible/v1"
+)
+
+var securityPresets = map[SecurityPreset]map[string]string{
+	PresetStrictHTMLV1: {
+		"X-Frame-Options":              "DENY",
+		"X-Xss-Protection":             "0",
+		"X-Content-Type-Options":       "nosniff",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Content-Security-Policy":      "default-src 'self'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; object-src 'none'; upgrade-insecure-requests",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Resource-Policy": "same-origin",
+		"Permissions-Policy":           "camera=(), geolocation=(), microphone=(), payment=(), usb=()",
+	},
+	PresetAPIV1: {
+		"X-Frame-Options":              "DENY",
+		"X-Xss-Protection":             "0",
+		"X-Content-Type-Options":       "nosniff",
+		"Referrer-Policy":              "no-referrer",
+		"Content-Security-Policy":      "default-src 'none'; frame-ancestors 'none'; sandbox",
+		"Cross-Origin-Resource-Policy": "same-origin",
+	},
+	PresetLegacyCompatibleV1: {
+		"X-Frame-Options":        "SAMEORIGIN",
+		"X-Xss-Protection":       "0",
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+	},
+}
+
+// legacySecurityHeaders are the headers set by
+// SecurityHeaders when no Preset is chosen.
+var legacySecurityHeaders = map[string]string{
+	"X-Frame-Options":        "SAMEORIGIN",
+	"X-Xss-Protection":       "1; mode=block",
+	"X-Content-Type-Options": "nosniff",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+}
+
+// Valid reports whether p is a known preset.
+func (p SecurityPreset) Valid() bool {
+	_, ok := securityPresets[p]
+	return ok
+}
+
+// Header returns a copy of the headers set by the preset,
+// or nil if p is not a known preset.
+func (p SecurityPreset) Header() http.Header {
+	preset, ok := securityPresets[p]
+	if !ok {
+		return nil
+	}
+
+	h := make(http.Header, len(preset))
+	for k, v := range preset {
+		h[k] = []string{v}
+	}
+
+	return h
+}
@@ -5,14 +5,16 @@
 
 package handlers
 
-import (
-	"net/http"
-	"net/url"
-)
+import "net/http"
 
 // SNIMatch verifies that the TLS SNI extension
 // matches the HTTP Host header.
 //
+// The hostnames are compared case-insensitively,
+// ignoring any trailing dot, and internationalized
+// domain names match in both their Unicode and
+// punycode forms.
+//
 // It does nothing for HTTP/2.0 to allow for
 // connection coalescing.
 //
@@ -44,7 +46,7 @@ type sniMatch struct {
 
 func (sm *sniMatch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.TLS == nil || r.TLS.ServerName == "" || r.ProtoMajor == 2 ||
-		r.TLS.ServerName == (&url.URL{Host: r.Host}).Hostname() {
+		lookupHost(r.TLS.ServerName) == requestHost(r) {
 		sm.h.ServeHTTP(w, r)
 	} else {
 		sm.mismatch.ServeHTTP(w, r)
@@ -91,3 +91,28 @@ func TestSNIMatchMismatchNoHandler(t *testing.T) {
 	require.IsType(t, (*errorHandler)(nil), sni.(*sniMatch).mismatch)
 	assert.Equal(t, http.StatusBadRequest, sni.(*sniMatch).mismatch.(*errorHandler).code)
 }
+
+func TestSNIMatchNormalize(t *testing.T) {
+	h1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	})
+	h2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(998)
+	})
+
+	for _, host := range []string{"Example.COM", "example.com.", "example.com.:1234"} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/path/to/file", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		SNIMatch(h1, h2).ServeHTTP(w, r)
+
+		assert.Equal(t, 999, w.Code, host)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/path/to/file", nil)
+	r.Host, r.TLS.ServerName = "bücher.example", "xn--bcher-kva.example"
+	w := httptest.NewRecorder()
+	SNIMatch(h1, h2).ServeHTTP(w, r)
+
+	assert.Equal(t, 999, w.Code)
+}
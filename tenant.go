@@ -0,0 +1,173 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type tenantKey struct{}
+
+// TenantFromContext returns the tenant resolved by
+// ResolveTenant, or nil if the request was not handled by
+// ResolveTenant.
+func TenantFromContext(ctx context.Context) interface{} {
+	return ctx.Value(tenantKey{})
+}
+
+// TenantResolver resolves a host to a tenant.
+type TenantResolver interface {
+	// ResolveTenant returns the tenant for the
+	// normalized host, and false if the host does not
+	// belong to a tenant.
+	ResolveTenant(ctx context.Context, host string) (tenant interface{}, ok bool, err error)
+}
+
+// TenantMap is a TenantResolver that maps normalized hosts
+// to tenants.
+type TenantMap map[string]interface{}
+
+// ResolveTenant implements TenantResolver.
+func (m TenantMap) ResolveTenant(ctx context.Context, host string) (interface{}, bool, error) {
+	tenant, ok := m[host]
+	return tenant, ok, nil
+}
+
+// CacheTenants returns a TenantResolver that caches the
+// results of resolver, including valid hosts that do not
+// belong to a tenant, for ttl. Errors are not cached.
+//
+// At most 10000 hosts are cached. Once the cache is full,
+// further hosts are resolved but not cached until existing
+// entries expire.
+func CacheTenants(resolver TenantResolver, ttl time.Duration) TenantResolver {
+	return &tenantCache{
+		resolver: resolver,
+		ttl:      ttl,
+		entries:  make(map[string]tenantCacheEntry),
+	}
+}
+
+const maxTenantCacheEntries = 10000
+
+type tenantCacheEntry struct {
+	tenant  interface{}
+	ok      bool
+	expires time.Time
+}
+
+type tenantCache struct {
+	resolver TenantResolver
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]tenantCacheEntry
+	lastSweep time.Time
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func (tc *tenantCache) time() time.Time {
+	if tc.now != nil {
+		return tc.now()
+	}
+
+	return time.Now()
+}
+
+func (tc *tenantCache) ResolveTenant(ctx context.Context, host string) (interface{}, bool, error) {
+	now := tc.time()
+
+	tc.mu.Lock()
+	e, hit := tc.entries[host]
+	tc.mu.Unlock()
+
+	if hit && now.Before(e.expires) {
+		return e.tenant, e.ok, nil
+	}
+
+	tenant, ok, err := tc.resolver.ResolveTenant(ctx, host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Don't cache misses for hosts that are not valid
+	// hostnames, which can only come from clients sending
+	// arbitrary Host headers.
+	if !ok {
+		if _, err := normalizeHost(host); err != nil {
+			return tenant, ok, nil
+		}
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// Remove expired entries, at most once per ttl, so
+	// that hosts that are no longer requested do not
+	// accumulate.
+	if now.Sub(tc.lastSweep) >= tc.ttl {
+		for host, e := range tc.entries {
+			if !now.Before(e.expires) {
+				delete(tc.entries, host)
+			}
+		}
+
+		tc.lastSweep = now
+	}
+
+	if _, cached := tc.entries[host]; cached || len(tc.entries) < maxTenantCacheEntries {
+		tc.entries[host] = tenantCacheEntry{tenant, ok, now.Add(tc.ttl)}
+	}
+
+	return tenant, ok, nil
+}
+
+// ResolveTenant wraps a http.Handler and resolves the
+// normalized hostname of the request's Host header to a
+// tenant with resolver. The tenant is available from
+// TenantFromContext.
+//
+// notFound is invoked for hosts that do not belong to a
+// tenant. If notFound is nil, a 403 Forbidden error will
+// be returned instead, as with HostSwitch. If resolver
+// returns an error, a 500 Internal Server Error is
+// returned.
+func ResolveTenant(h http.Handler, resolver TenantResolver, notFound http.Handler) Handler {
+	return &tenantResolver{h, resolver, notFound}
+}
+
+// ResolveTenantWrap returns a Middleware that calls
+// ResolveTenant.
+func ResolveTenantWrap(resolver TenantResolver, notFound http.Handler) Middleware {
+	return func(h http.Handler) http.Handler {
+		return ResolveTenant(h, resolver, notFound)
+	}
+}
+
+type tenantResolver struct {
+	h        http.Handler
+	resolver TenantResolver
+	notFound http.Handler
+}
+
+func (tr *tenantResolver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant, ok, err := tr.resolver.ResolveTenant(r.Context(), requestHost(r))
+	switch {
+	case err != nil:
+		http.Error(w, internalServerErrorText, http.StatusInternalServerError)
+	case ok:
+		tr.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	case tr.notFound != nil:
+		tr.notFound.ServeHTTP(w, r)
+	default:
+		http.Error(w, forbiddenText, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTenant(t *testing.T) {
+	h := ResolveTenantWrap(TenantMap{
+		"acme.example.com":   "acme",
+		"globex.example.com": "globex",
+	}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Tenant", TenantFromContext(r.Context()).(string))
+	}))
+
+	for _, tc := range []struct {
+		host, tenant string
+		code         int
+	}{
+		{"acme.example.com", "acme", http.StatusOK},
+		{"Globex.Example.com.:8443", "globex", http.StatusOK},
+		{"initech.example.com", "", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tc.code, w.Code, tc.host)
+		assert.Equal(t, tc.tenant, w.Header().Get("X-Tenant"), tc.host)
+	}
+
+	assert.Nil(t, TenantFromContext(context.Background()))
+}
+
+type tenantResolverFunc func(ctx context.Context, host string) (interface{}, bool, error)
+
+func (f tenantResolverFunc) ResolveTenant(ctx context.Context, host string) (interface{}, bool, error) {
+	return f(ctx, host)
+}
+
+func TestResolveTenantNotFound(t *testing.T) {
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(999)
+	})
+	resolver := tenantResolverFunc(func(ctx context.Context, host string) (interface{}, bool, error) {
+		if host == "error.example.com" {
+			return nil, false, errors.New("database unavailable")
+		}
+
+		return nil, false, nil
+	})
+
+	h := ResolveTenant(http.NotFoundHandler(), resolver, notFound)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, 999, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://error.example.com/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestCacheTenants(t *testing.T) {
+	calls := make(map[string]int)
+	fail := false
+	resolver := tenantResolverFunc(func(ctx context.Context, host string) (interface{}, bool, error) {
+		calls[host]++
+
+		if fail {
+			return nil, false, errors.New("database unavailable")
+		}
+
+		return TenantMap{"acme.example.com": "acme"}.ResolveTenant(ctx, host)
+	})
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := CacheTenants(resolver, time.Minute).(*tenantCache)
+	tc.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		tenant, ok, err := tc.ResolveTenant(context.Background(), "acme.example.com")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "acme", tenant)
+
+		_, ok, err = tc.ResolveTenant(context.Background(), "initech.example.com")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	assert.Equal(t, map[string]int{"acme.example.com": 1, "initech.example.com": 1}, calls)
+
+	now = now.Add(time.Minute)
+	fail = true
+
+	_, _, err := tc.ResolveTenant(context.Background(), "acme.example.com")
+	assert.Error(t, err)
+
+	fail = false
+
+	tenant, ok, err := tc.ResolveTenant(context.Background(), "acme.example.com")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenant)
+
+	assert.Equal(t, map[string]int{"acme.example.com": 3, "initech.example.com": 1}, calls)
+	assert.NotContains(t, tc.entries, "initech.example.com")
+}
+
+func TestCacheTenantsLimit(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := CacheTenants(TenantMap{"acme.example.com": "acme"}, time.Minute).(*tenantCache)
+	tc.now = func() time.Time { return now }
+
+	for i := 0; i < maxTenantCacheEntries+100; i++ {
+		_, ok, err := tc.ResolveTenant(context.Background(), fmt.Sprintf("host%d.example.com", i))
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	assert.Len(t, tc.entries, maxTenantCacheEntries)
+
+	_, ok, err := tc.ResolveTenant(context.Background(), "acme.example.com")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NotContains(t, tc.entries, "acme.example.com")
+
+	now = now.Add(time.Minute)
+
+	_, ok, _ = tc.ResolveTenant(context.Background(), "acme.example.com")
+	assert.True(t, ok)
+	assert.Len(t, tc.entries, 1)
+
+	_, ok, _ = tc.ResolveTenant(context.Background(), "exa mple.com")
+	assert.False(t, ok)
+	assert.NotContains(t, tc.entries, "exa mple.com")
+}
@@ -0,0 +1,354 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VirtualHostsConfig is the JSON configuration read by
+// ParseVirtualHosts. For example:
+//
+//	{
+//		"hosts": {
+//			"example.com": {
+//				"type": "content",
+//				"name": "index.html",
+//				"content": "<!doctype html>...",
+//				"middleware": [
+//					{"type": "security-headers", "preset": "strict-html/v1"},
+//					{"type": "set-header", "name": "Cache-Control", "value": "max-age=300"}
+//				]
+//			},
+//			"www.example.com": {"type": "host-redirect", "host": "example.com", "code": 301},
+//			"*.example.net": {"type": "redirect-to-https"},
+//			"old.example.com": {"type": "redirect", "url": "https://example.com/", "code": 308},
+//			"gone.example.com": {"type": "error", "code": 410}
+//		}
+//	}
+type VirtualHostsConfig struct {
+	Hosts map[string]VirtualHost `json:"hosts"`
+}
+
+// VirtualHost is the configuration of a single host in
+// a VirtualHostsConfig.
+//
+// Type selects the handler and which of the other
+// fields are used:
+//  - content: ServeString with Name and Content,
+//  - redirect: http.RedirectHandler with URL and Code,
+//  - host-redirect: HostRedirect with Host and Code,
+//  - redirect-to-https: RedirectToHTTPS with Host,
+//    Port and Code, and
+//  - error: ErrorMessage with Message and Code, or
+//    ErrorCode if Message is empty.
+type VirtualHost struct {
+	Type string `json:"type"`
+
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Port    string `json:"port,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Middleware wraps the handler, the first entry
+	// being the outermost.
+	Middleware []VirtualHostMiddleware `json:"middleware,omitempty"`
+}
+
+// VirtualHostMiddleware is the configuration of a
+// middleware in a VirtualHost.
+//
+// Type selects the middleware and which of the other
+// fields are used:
+//  - set-header: SetHeader with Name and Value,
+//  - add-header: AddHeader with Name and Value,
+//  - delete-header: DeleteHeader with Name,
+//  - security-headers: SecurityHeaders with Preset,
+//  - never-modified: NeverModified, and
+//  - sni-match: SNIMatch.
+type VirtualHostMiddleware struct {
+	Type string `json:"type"`
+
+	Name   string         `json:"name,omitempty"`
+	Value  string         `json:"value,omitempty"`
+	Preset SecurityPreset `json:"preset,omitempty"`
+}
+
+// ParseVirtualHosts reads a JSON VirtualHostsConfig from
+// r and returns the handler for each host, for use with
+// SafeHostSwitch.SetAll.
+//
+// It returns an error if the configuration is invalid.
+func ParseVirtualHosts(r io.Reader) (map[string]http.Handler, error) {
+	var config VirtualHostsConfig
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return nil, fmt.Errorf("handlers: invalid virtual hosts config: %v", err)
+	}
+
+	return config.Handlers()
+}
+
+// Handlers returns the handler for each host.
+//
+// It returns an error if the configuration is invalid.
+func (c *VirtualHostsConfig) Handlers() (map[string]http.Handler, error) {
+	// Sort the hosts so that any error is
+	// deterministic.
+	hosts := make([]string, 0, len(c.Hosts))
+	for host := range c.Hosts {
+		hosts = append(hosts, host)
+	}
+
+	sort.Strings(hosts)
+
+	handlers := make(map[string]http.Handler, len(hosts))
+	for _, host := range hosts {
+		if _, _, err := parseHostPattern(host); err != nil {
+			return nil, fmt.Errorf("handlers: invalid virtual host %q: %v", host, err)
+		}
+
+		vh := c.Hosts[host]
+
+		h, err := vh.Handler()
+		if err != nil {
+			return nil, fmt.Errorf("handlers: invalid virtual host %q: %v", host, err)
+		}
+
+		handlers[host] = h
+	}
+
+	return handlers, nil
+}
+
+// Handler returns the handler for the virtual host,
+// wrapped in its middleware.
+func (vh *VirtualHost) Handler() (http.Handler, error) {
+	var h http.Handler
+
+	switch vh.Type {
+	case "content":
+		if vh.Name == "" {
+			return nil, fmt.Errorf("content requires a name")
+		}
+
+		h = ServeString(vh.Name, time.Time{}, vh.Content)
+	case "redirect":
+		if vh.URL == "" {
+			return nil, fmt.Errorf("redirect requires a url")
+		}
+
+		code, err := redirectCode(vh.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		h = http.RedirectHandler(vh.URL, code)
+	case "host-redirect":
+		host, err := normalizeHost(vh.Host)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("host-redirect requires a valid host")
+		}
+
+		code, err := redirectCode(vh.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		h = HostRedirect(host, code)
+	case "redirect-to-https":
+		if vh.Code != 0 {
+			if _, err := redirectCode(vh.Code); err != nil {
+				return nil, err
+			}
+		}
+
+		h = &RedirectToHTTPS{
+			Host: vh.Host,
+			Port: vh.Port,
+			Code: vh.Code,
+		}
+	case "error":
+		if vh.Code < 400 || vh.Code > 599 {
+			return nil, fmt.Errorf("invalid error code %d", vh.Code)
+		}
+
+		if vh.Message != "" {
+			h = ErrorMessage(vh.Message, vh.Code)
+		} else {
+			h = ErrorCode(vh.Code)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", vh.Type)
+	}
+
+	for i := len(vh.Middleware) - 1; i >= 0; i-- {
+		var err error
+		if h, err = vh.Middleware[i].wrap(h); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func redirectCode(code int) (int, error) {
+	switch {
+	case code == 0:
+		return http.StatusMovedPermanently, nil
+	case code >= 300 && code <= 399:
+		return code, nil
+	default:
+		return 0, fmt.Errorf("invalid redirect code %d", code)
+	}
+}
+
+func (m *VirtualHostMiddleware) wrap(h http.Handler) (http.Handler, error) {
+	switch m.Type {
+	case "set-header", "add-header", "delete-header":
+		if m.Name == "" {
+			return nil, fmt.Errorf("%s requires a name", m.Type)
+		}
+	}
+
+	switch m.Type {
+	case "set-header":
+		return SetHeader(h, m.Name, m.Value), nil
+	case "add-header":
+		return AddHeader(h, m.Name, m.Value), nil
+	case "delete-header":
+		return DeleteHeader(h, m.Name), nil
+	case "security-headers":
+		sh := &SecurityHeaders{Handler: h, Preset: m.Preset}
+		if err := sh.Validate(); err != nil {
+			return nil, err
+		}
+
+		return sh, nil
+	case "never-modified":
+		return NeverModified(h), nil
+	case "sni-match":
+		return SNIMatch(h, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown middleware type %q", m.Type)
+	}
+}
+
+// LoadVirtualHosts reads the JSON VirtualHostsConfig in
+// the named file and atomically replaces the hosts in hs
+// with it.
+//
+// If the configuration is invalid, it returns an error
+// and hs is left unchanged.
+func LoadVirtualHosts(hs *SafeHostSwitch, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return loadVirtualHosts(hs, data)
+}
+
+func loadVirtualHosts(hs *SafeHostSwitch, data []byte) error {
+	handlers, err := ParseVirtualHosts(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return hs.SetAll(handlers)
+}
+
+// WatchVirtualHosts loads the named file, as with
+// LoadVirtualHosts, then polls it every interval and
+// reloads it whenever its contents change. It returns a
+// function that stops watching the file.
+//
+// If interval is not positive or the file cannot be
+// loaded initially, it returns an error, hs is left
+// unchanged and the file is not watched.
+//
+// Errors reading or reloading the file are passed to
+// onError, or written to os.Stderr if onError is nil.
+// An invalid configuration does not disturb the hosts
+// in hs, and is not retried until the file changes
+// again.
+func WatchVirtualHosts(hs *SafeHostSwitch, path string, interval time.Duration, onError func(error)) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("handlers: invalid virtual hosts watch interval %v", interval)
+	}
+
+	if onError == nil {
+		onError = func(err error) {
+			fmt.Fprintf(os.Stderr, "handlers: failed to reload virtual hosts: %v\n", err)
+		}
+	}
+
+	// Compare later reads against the contents that were
+	// actually loaded, so that a change made before the
+	// first poll is not mistaken for the loaded file.
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadVirtualHosts(hs, last); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+
+			if bytes.Equal(data, last) {
+				continue
+			}
+
+			last = data
+
+			if err := loadVirtualHosts(hs, data); err != nil {
+				onError(err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Tom Thorogood. All rights reserved.
+// Use of this source code is governed by a
+// Modified BSD License that can be found in
+// the LICENSE file.
+
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVirtualHostsConfig = `{
+	"hosts": {
+		"example.com": {
+			"type": "content",
+			"name": "index.txt",
+			"content": "hello",
+			"middleware": [
+				{"type": "security-headers", "preset": "api/v1"},
+				{"type": "set-header", "name": "X-Test", "value": "1"}
+			]
+		},
+		"www.example.com": {"type": "host-redirect", "host": "example.com", "code": 308},
+		"*.example.net": {"type": "redirect-to-https"},
+		"old.example.com": {"type": "redirect", "url": "https://example.com/new"},
+		"gone.example.com": {"type": "error", "code": 410, "message": "gone away"}
+	}
+}`
+
+func TestParseVirtualHosts(t *testing.T) {
+	handlers, err := ParseVirtualHosts(strings.NewReader(testVirtualHostsConfig))
+	require.NoError(t, err)
+
+	var hs SafeHostSwitch
+	require.NoError(t, hs.SetAll(handlers))
+
+	for _, tc := range []struct {
+		url      string
+		code     int
+		location string
+		body     string
+	}{
+		{"http://example.com/", http.StatusOK, "", "hello"},
+		{"http://www.example.com/path", http.StatusPermanentRedirect, "http://example.com/path", ""},
+		{"http://a.example.net/path", http.StatusMovedPermanently, "https://a.example.net/path", ""},
+		{"http://old.example.com/path", http.StatusMovedPermanently, "https://example.com/new", ""},
+		{"http://gone.example.com/", http.StatusGone, "", "gone away\n"},
+	} {
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+		assert.Equal(t, tc.code, w.Code, tc.url)
+		assert.Equal(t, tc.location, w.Header().Get("Location"), tc.url)
+
+		if tc.body != "" {
+			assert.Equal(t, tc.body, w.Body.String(), tc.url)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	hs.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, "1", w.Header().Get("X-Test"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	for _, config := range []string{
+		`{"hosts": {"example.com": {"type": "content"}}}`,
+		`{"hosts": {"example.com": {"type": "redirect", "url": "/", "code": 200}}}`,
+		`{"hosts": {"example.com": {"type": "host-redirect"}}}`,
+		`{"hosts": {"example.com": {"type": "redirect-to-https", "code": 404}}}`,
+		`{"hosts": {"example.com": {"type": "error", "code": 302}}}`,
+		`{"hosts": {"example.com": {"type": "proxy"}}}`,
+		`{"hosts": {"exa mple.com": {"type": "error", "code": 404}}}`,
+		`{"hosts": {"example.com": {"type": "error", "code": 404, "middleware": [{"type": "set-header"}]}}}`,
+		`{"hosts": {"example.com": {"type": "error", "code": 404, "middleware": [{"type": "security-headers", "preset": "strict/v0"}]}}}`,
+		`{"hosts": {"example.com": {"type": "error", "code": 404, "middleware": [{"type": "gzip"}]}}}`,
+		`{"hosts": []}`,
+	} {
+		_, err := ParseVirtualHosts(strings.NewReader(config))
+		if assert.Error(t, err, config) && !strings.HasPrefix(config, `{"hosts": []`) {
+			assert.Contains(t, err.Error(), "invalid virtual host ", config)
+		}
+	}
+}
+
+func TestLoadVirtualHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlers-virtual-hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testVirtualHostsConfig), 0600))
+
+	var hs SafeHostSwitch
+	require.NoError(t, LoadVirtualHosts(&hs, path))
+
+	assert.Equal(t, []string{"*.example.net", "example.com", "gone.example.com", "old.example.com", "www.example.com"}, hs.Hosts())
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	stop, err := WatchVirtualHosts(&hs, path, 5*time.Millisecond, func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	})
+	require.NoError(t, err)
+	defer stop()
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hosts": {"example.org": {"type": "error", "code": 404}}}`), 0600))
+
+	assert.Eventually(t, func() bool {
+		hosts := hs.Hosts()
+		return len(hosts) == 1 && hosts[0] == "example.org"
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hosts": {"example.com": {"type": "bogus"}}}`), 0600))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, []string{"example.org"}, hs.Hosts())
+
+	stop()
+	stop()
+
+	assert.Error(t, LoadVirtualHosts(&hs, filepath.Join(dir, "missing.json")))
+
+	_, err = WatchVirtualHosts(&hs, filepath.Join(dir, "missing.json"), time.Millisecond, nil)
+	assert.Error(t, err)
+
+	assert.NotPanics(t, func() {
+		_, err = WatchVirtualHosts(&hs, path, 0, nil)
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"example.org"}, hs.Hosts())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hosts": {"example.com": {"type": "bogus"}}}`), 0600))
+
+	_, err = WatchVirtualHosts(&hs, path, time.Millisecond, nil)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"example.org"}, hs.Hosts())
+}
+
+func TestWatchVirtualHostsInitialLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handlers-virtual-hosts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hosts": {"example.com": {"type": "error", "code": 404}}}`), 0600))
+
+	var hs SafeHostSwitch
+	require.NoError(t, LoadVirtualHosts(&hs, path))
+
+	// A change made between LoadVirtualHosts and
+	// WatchVirtualHosts must not be missed.
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"hosts": {"example.org": {"type": "error", "code": 404}}}`), 0600))
+
+	stop, err := WatchVirtualHosts(&hs, path, time.Hour, nil)
+	require.NoError(t, err)
+	defer stop()
+
+	assert.Equal(t, []string{"example.org"}, hs.Hosts())
+}